@@ -34,30 +34,372 @@ License
 -------
 MIT License
 """
+import base64
+import codecs
+import csv
+import functools
+import hashlib
+import hmac
+import html
+import importlib.metadata
+import inspect
+import io
+import ipaddress
+import itertools
+import tempfile
+import json
 import os
+import re
+import platform
+import ssl
+import shutil
+import shlex
+import signal
 import subprocess
+import sys
+import time
+import threading
+import traceback
+import uuid
+import urllib.request
+import zipfile
+import zoneinfo
+from collections import Counter
+from contextlib import contextmanager, nullcontext
+from datetime import datetime, timezone
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+from urllib.parse import parse_qs, quote, urlparse
 
+import click
 import pandas as pd
 import typer
+from click.shell_completion import get_completion_class
+from dateutil.rrule import rrulestr
 from pathlib import Path
-from rich.console import Console
+from rich.console import Console, Group
+from rich.text import Text
+from rich import box
 from rich.table import Table
 
+try:
+    import tomllib
+except ImportError:  # python < 3.11
+    import tomli as tomllib
+
+__all__ = [
+    "Takt",
+    "FileRow",
+    "FileManager",
+    "Record",
+    "Store",
+    "Aggregator",
+    "GitSync",
+    "TaktError",
+    "Clock",
+    "FixedClock",
+    "load_config",
+    "format_time",
+    "to_hours",
+]
+
 app = typer.Typer()
 console = Console()
+err_console = Console(stderr=True)
+state = {
+    "lenient": False,
+    "lock": True,
+    "profile": None,
+    "style": None,
+    "json": False,
+    "pager": True,
+    "humanize": False,
+    "plain": False,
+    "trace": False,
+}
 
 DEFAULT_FILE = '~/.takt_file.csv'
 FILE_NAME = os.path.expanduser(os.getenv('TAKT_FILE', DEFAULT_FILE))
+DEFAULT_CONFIG = '~/.config/takt/config.toml'
+CONFIG_FILE = os.path.expanduser(os.getenv('TAKT_CONFIG', DEFAULT_CONFIG))
+STATE_DIR = os.path.join(
+    os.path.expanduser(os.getenv('XDG_STATE_HOME', '~/.local/state')), 'takt'
+)
+PROFILE_FILE = os.path.join(STATE_DIR, 'profile')
+BACKUP_DIR = os.path.join(STATE_DIR, 'backups')
+QUEUE_DIR = os.path.join(STATE_DIR, 'queue')
+QUEUE_FILE = os.path.join(QUEUE_DIR, 'queue.json')
+INDEX_DIR = os.path.join(STATE_DIR, 'index')
+SYNC_DIR = os.path.join(STATE_DIR, 'sync')
+# summaries show the newest groups, those can be read from the index
+SUMMARY_LIMIT = 10
+RECENT_PERIODS = ("daily", "wtd", "mtd")
+SUMMARY_PERIODS = ("daily", "wtd", "mtd", "quarter", "half", "ytd")
+QUEUE_BACKOFF = 60
+QUEUE_BACKOFF_MAX = 3600
+# a hook still failing after a day of retries is dropped
+QUEUE_MAX_ATTEMPTS = 30
+PRESENCE_STATUS = "In a deep-work session"
+PRESENCE_EMOJI = ":red_circle:"
+BACKUP_KEEP = 20
+JOURNAL_DAYS = 90
 
 TIMESTAMP = "timestamp"
 KIND = "kind"
 NOTES = "notes"
+META = "meta"
 COLUMNS = [
     TIMESTAMP,
     KIND,
     NOTES,
 ]
+# optional columns, only written when some record uses them
+EXTENDED_COLUMNS = [
+    META,
+]
 SECONDS_TO_HOURS = 1 / 3600
+# fewer members and a week's total would tell someone's hours
+TEAM_MIN_MEMBERS = 3
+
+EXIT_ERROR = 1
+EXIT_INVALID_ARGS = 2
+EXIT_FILE_MISSING = 3
+EXIT_PARSE_ERROR = 4
+EXIT_GIT_ERROR = 5
+EXIT_LOCK_ERROR = 6
+LOCK_TIMEOUT = 10
+
+DEFAULT_HOST = "127.0.0.1"
+DEFAULT_PORT = 8765
+EVENT_KEEPALIVE = 15
+SYNC_RETRY = 5
+FEED_PREFIX = "/feeds/"
+CALENDAR_FEED = "/calendar.ics"
+# signs the calendar feed, no project can be named like this
+ALL_SESSIONS = "*"
+METRICS_DAYS = 90
+TEAM_WEEKS = 8
+REPORT_STYLES = ("table", "plain", "tsv")
+DEFAULT_CURRENCY = "EUR"
+DEFAULT_PAGER = "less -R"
+UI_REFRESH = 1000
+PICKER_RECORDS = 500
+EXCEL_DECIMAL = ","
+EXPORT_FORMATS = ("excel-csv",)
+COMPLETION_SHELLS = ("bash", "zsh", "fish", "powershell")
+SIGN_METHODS = ("minisign", "ssh")
+SSH_SIGN_NAMESPACE = "takt"
+TASKWARRIOR_ENV = "TAKT_TASKWARRIOR"
+WEEK_TAG = "week-{year}-W{week:02d}"
+TASKWARRIOR_HOOK = "~/.task/hooks/on-modify.takt"
+DAILY_TARGET = "8h"
+HEATMAP_COLORS = ("grey23", "dark_green", "green4", "green3", "green1")
+HEATMAP_OVERWORK = 1.25
+BAR_EIGHTHS = " ▏▎▍▌▋▊▉"
+CHART_PERIODS = ("daily", "weekly")
+BURNUP_SVG_SIZE = (640, 320)
+OVERLAP_MODES = ("warn", "error", "merge", "truncate")
+OVERLAP_FIXES = ("merge", "truncate")
+NOTES_WIDTH = 60
+SUSPICIOUS_SESSION = "14h"
+SUSPICIOUS_MARK = "⚠"
+BACKUP_NAME = "records"
+OIDC_CACHE = 300
+OIDC_CACHE_SIZE = 1024
+# pbkdf2 rounds of new basic auth passwords, each hash stores its own
+PASSWORD_ITERATIONS = 600_000
+PASSWORD_SCHEME = "pbkdf2_sha256"
+AUTH_METHODS = ("token", "basic", "oidc", "mtls")
+
+BREAK_START = "break-start"
+BREAK_END = "break-end"
+CHECKED_IN_KINDS = ("in", BREAK_START, BREAK_END)
+TASK_START = "task-start"
+TASK_STOP = "task-stop"
+TASK_KINDS = (TASK_START, TASK_STOP)
+# national holidays: (month, day, name) and (days from Easter, name)
+HOLIDAYS = {
+    "ES": (
+        [(1, 1, "Año Nuevo"), (1, 6, "Epifanía del Señor"),
+         (5, 1, "Fiesta del Trabajo"), (8, 15, "Asunción de la Virgen"),
+         (10, 12, "Fiesta Nacional de España"), (11, 1, "Todos los Santos"),
+         (12, 6, "Día de la Constitución"), (12, 8, "Inmaculada Concepción"),
+         (12, 25, "Navidad")],
+        [(-2, "Viernes Santo")],
+    ),
+    "DE": (
+        [(1, 1, "Neujahr"), (5, 1, "Tag der Arbeit"),
+         (10, 3, "Tag der Deutschen Einheit"), (12, 25, "Erster Weihnachtstag"),
+         (12, 26, "Zweiter Weihnachtstag")],
+        [(-2, "Karfreitag"), (1, "Ostermontag"), (39, "Christi Himmelfahrt"),
+         (50, "Pfingstmontag")],
+    ),
+    "FR": (
+        [(1, 1, "Jour de l'an"), (5, 1, "Fête du Travail"),
+         (5, 8, "Victoire 1945"), (7, 14, "Fête nationale"),
+         (8, 15, "Assomption"), (11, 1, "Toussaint"), (11, 11, "Armistice"),
+         (12, 25, "Noël")],
+        [(1, "Lundi de Pâques"), (39, "Ascension"), (50, "Lundi de Pentecôte")],
+    ),
+    "IT": (
+        [(1, 1, "Capodanno"), (1, 6, "Epifania"), (4, 25, "Liberazione"),
+         (5, 1, "Festa del Lavoro"), (6, 2, "Festa della Repubblica"),
+         (8, 15, "Ferragosto"), (11, 1, "Ognissanti"),
+         (12, 8, "Immacolata Concezione"), (12, 25, "Natale"),
+         (12, 26, "Santo Stefano")],
+        [(1, "Lunedì dell'Angelo")],
+    ),
+    "PT": (
+        [(1, 1, "Ano Novo"), (4, 25, "Dia da Liberdade"),
+         (5, 1, "Dia do Trabalhador"), (6, 10, "Dia de Portugal"),
+         (8, 15, "Assunção de Nossa Senhora"), (10, 5, "Implantação da República"),
+         (11, 1, "Todos os Santos"), (12, 1, "Restauração da Independência"),
+         (12, 8, "Imaculada Conceição"), (12, 25, "Natal")],
+        [(-2, "Sexta-feira Santa"), (60, "Corpo de Deus")],
+    ),
+}
+LEAVE = "off"
+LEAVE_TYPES = ("vacation", "sick", "holiday")
+LEAVE_HALVES = ("am", "pm")
+# records that are not part of the check in/out sequence
+SIDE_KINDS = TASK_KINDS + (LEAVE,)
+RECORD_KINDS = ("in", "out", BREAK_START, BREAK_END, TASK_START, TASK_STOP, LEAVE)
+PROJECT_PATTERN = re.compile(r"(?:^|\s)\+([\w.-]+)")
+# Jira keys like PROJ-123, GitHub issues like #456 or owner/repo#456
+ISSUE_PATTERN = re.compile(
+    r"(?<![\w#/-])([A-Z][A-Z0-9]+-\d+|(?:[\w.-]+/[\w.-]+)?#\d+)(?![\w-])"
+)
+JIRA_KEY = re.compile(r"[A-Z][A-Z0-9]+-\d+")
+PUSHED_FILE = os.path.join(STATE_DIR, 'pushed.json')
+STATUS_VERSION = 1
+PROMPT_FORMAT = "⏱ {state} {elapsed}"
+ISO_TIMESTAMP = re.compile(
+    r"\d{4}-\d{2}-\d{2}(?:[T ]\d{2}:\d{2}(?::\d{2}(?:\.\d+)?)?)?"
+    r"(?:Z|[+-]\d{2}:?\d{2})?"
+)
+# older takt wrote seconds-less or microsecond timestamps, spreadsheets slashes
+LEGACY_TIMESTAMP = re.compile(
+    r"(\d{4})[-/](\d{2})[-/](\d{2})[T ](\d{1,2}):(\d{2})"
+    r"(?::(\d{2})(?:[.,](\d{1,9}))?)?"
+)
+# the pandas based takt wrote missing notes as NaN
+LEGACY_NAN = ("nan", "NaN")
+TIME_ONLY = re.compile(r"\d{1,2}:\d{2}(?::\d{2}(?:\.\d+)?)?")
+DURATION_PATTERN = re.compile(r"(\d+(?:\.\d+)?)\s*([dhms])")
+DURATION_UNITS = {"d": "days", "h": "hours", "m": "minutes", "s": "seconds"}
+REMIND_INTERVAL = "2h"
+REMIND_SNOOZE = "15m"
+REMIND_POLL = 60
+NOTIFY_MAX_SESSION = "10h"
+NOTIFY_SNOOZE = "30m"
+WORKDAY = "8h"
+AUTO_OUT_COMMANDS = (
+    "check", "status", "break", "resume", "start", "stop", "tasks",
+    "pomodoro", "display", "summary", "wtd", "mtd", "ytd", "quarter", "half",
+    "compliance",
+)
+DAY_NAMES = ("mon", "tue", "wed", "thu", "fri", "sat", "sun")
+SCHEDULE_DAYS = {
+    "daily": set(range(7)),
+    "weekdays": set(range(5)),
+    "weekends": {5, 6},
+}
+POMODORO_WORK = "25m"
+POMODORO_BREAK = "5m"
+WEEK_STARTS = {"sunday": "%U", "monday": "%W"}
+WEEK_FREQS = {"sunday": "W-SAT", "monday": "W-SUN"}
+DEFAULT_WEEK_START = "sunday"
+MAX_WEEKLY_HOURS = 48
+AVERAGE_WEEKS = 17
+MIN_DAILY_REST = "11h"
+TIMESTAMP_HINT = (
+    "Timestamps must be ISO 8601, e.g. '2023-11-02 09:00:00' or "
+    "'2023-11-02T09:00:00+01:00'. Fix the file with `takt edit` or run "
+    "with `--lenient` to skip bad lines."
+)
+
+
+class Clock:
+    """Source of the current time.
+
+    Everything in takt asks `clock` for the time, replace it with a
+    `FixedClock` to get deterministic results in tests or scripts.
+    """
+
+    def now(self) -> pd.Timestamp:
+        return pd.Timestamp.now()
+
+
+class FixedClock(Clock):
+    """Clock stopped at `timestamp`, moved only by `advance`."""
+
+    def __init__(self, timestamp):
+        self.timestamp = pd.Timestamp(timestamp)
+
+    def now(self) -> pd.Timestamp:
+        return self.timestamp
+
+    def advance(self, delta):
+        self.timestamp += pd.Timedelta(delta)
+
+
+clock = FixedClock(os.environ["TAKT_NOW"]) if os.getenv("TAKT_NOW") else Clock()
+
+
+class Trace:
+    """Time spent and records handled in each phase, shown with `--trace`."""
+
+    def __init__(self):
+        self.start = time.perf_counter()
+        # phase -> [seconds, calls, records]
+        self.phases = {}
+        self.active = set()
+
+    @contextmanager
+    def phase(self, name):
+        # nested calls of the same phase are timed once
+        if name in self.active:
+            yield
+            return
+        self.active.add(name)
+        start = time.perf_counter()
+        try:
+            yield
+        finally:
+            self.active.discard(name)
+            entry = self.phases.setdefault(name, [0.0, 0, 0])
+            entry[0] += time.perf_counter() - start
+            entry[1] += 1
+
+    def count(self, name, records):
+        self.phases.setdefault(name, [0.0, 0, 0])[2] += records
+
+    def report(self) -> str:
+        lines = [
+            f"{name:<10} {seconds * 1000:9.1f} ms {calls:4d} calls "
+            f"{records:8d} records"
+            for name, (seconds, calls, records) in self.phases.items()
+        ]
+        total = time.perf_counter() - self.start
+        lines.append(f"{'total':<10} {total * 1000:9.1f} ms")
+        return "\n".join(lines)
+
+
+trace = Trace()
+
+
+def traced(name):
+    """Time every call of the decorated function as the phase `name`."""
+    def decorator(func):
+        @functools.wraps(func)
+        def wrapper(*args, **kwargs):
+            with trace.phase(name):
+                return func(*args, **kwargs)
+        return wrapper
+    return decorator
+
+
+# Filled by main(), so importing takt never imports third party modules.
+plugins = {}
 
 
 def load_plugins(prefix):
@@ -81,441 +423,8052 @@ def load_plugins(prefix):
     return out
 
 
-class FileRow(dict):
-    def __init__(self, timestamp, kind, notes):
-        super().__init__(timestamp=timestamp, kind=kind, notes=notes)
-
+def project_of(notes: str):
+    """Return the project of a note, tagged as `+project`, or None."""
+    match = PROJECT_PATTERN.search(notes or "")
+    return match.group(1) if match else None
 
-class FileManager:
-    columns = COLUMNS
 
-    def __init__(self, filename):
-        self.filename = filename
+def issues_of(notes: str) -> list[str]:
+    """Issue references of a note, `PROJ-123` or `#456`, in order."""
+    return list(dict.fromkeys(ISSUE_PATTERN.findall(notes or "")))
 
-    def read(self, nrows=None):
-        if not self.exists():
-            raise ValueError(f"File {self.filename} does not exist.")
-        data = pd.read_csv(self.filename, nrows=nrows)
-        if data.empty:
-            return data
-        # clean trailing spaces
-        data = strip_values(data)[self.columns]
-        data.fillna('', inplace=True)
-        data.timestamp = data.timestamp.apply(pd.Timestamp).astype(
-            "datetime64[ns]"
-        )
-        return data
 
-    def exists(self, create=True):
-        if Path(self.filename).exists():
-            return True
-        if create:
-            pd.DataFrame(columns=self.columns).to_csv(
-                self.filename, index=False
-            )
-            return True
-        return False
+def rename_project(notes: str, old: str, new: str) -> str:
+    """Replace the `+old` project tag by `+new`."""
+    pattern = rf"(?<!\S)\+{re.escape(old)}(?![\w.-])"
+    return re.sub(pattern, f"+{new}", notes or "")
 
-    def load(self, nrows=None) -> list[dict[str, float | str]]:
-        data = self.read(nrows=nrows)
-        return data.to_dict('records')
 
-    def save(self, records):
-        data = pd.DataFrame(records)[self.columns]
-        data.to_csv(self.filename, index=False)
+def rename_config_key(text: str, old: str, new: str) -> tuple[str, int]:
+    """Rename `old` where it is used as a key or a table name in TOML."""
+    name = rf"(?P<q>\"?){re.escape(old)}(?P=q)"
+    key = re.compile(rf"^(?P<pre>\s*){name}(?P<post>\s*=)", re.MULTILINE)
+    table = re.compile(
+        rf"^(?P<pre>\s*\[[^\]\n]*\.){name}(?P<post>\s*\])", re.MULTILINE
+    )
+    repl = rf"\g<pre>\g<q>{new}\g<q>\g<post>"
+    text, n_keys = key.subn(repl, text)
+    text, n_tables = table.subn(repl, text)
+    return text, n_keys + n_tables
 
-    def insert(self, **kwargs):
-        records = self.load()
-        records.insert(0, kwargs)
-        self.save(records)
 
-    def first(self):
-        data = self.read(nrows=1)
-        if data.empty:
-            return None
-        return data.to_dict('records')[0]
+def toml_key(name: str) -> str:
+    return name if re.fullmatch(r"[\w-]+", name) else json.dumps(name)
 
-    def records_of_week(self, year, week):
-        df = self.read()
-        df[TIMESTAMP] = pd.to_datetime(df[TIMESTAMP])
-        df['week'] = df[TIMESTAMP].dt.strftime('%U')
-        df['year'] = df[TIMESTAMP].dt.year
-        return df[(df['week'] == str(week)) & (df['year'] == year)]
 
+def toml_value(value) -> str:
+    if isinstance(value, (list, tuple)):
+        return "[" + ", ".join(toml_value(v) for v in value) + "]"
+    if isinstance(value, bool):
+        return "true" if value else "false"
+    if isinstance(value, (int, float)):
+        return repr(value)
+    return json.dumps(str(value), ensure_ascii=False)
 
-class DailyRef:
-    @staticmethod
-    def group(timestamp):
-        group_by = timestamp.date().isoformat()
-        return group_by
 
+def set_config_key(text: str, table: str, key: str, value) -> str:
+    """Add `key = value` to `[table]` in TOML, creating the table if missing."""
+    line = f"{toml_key(key)} = {toml_value(value)}\n"
+    header = re.search(
+        rf"^\[[ \t]*{re.escape(table)}[ \t]*\][ \t]*$", text, re.MULTILINE
+    )
+    if header is None:
+        if text and not text.endswith("\n"):
+            text += "\n"
+        return f"{text}\n[{table}]\n{line}" if text else f"[{table}]\n{line}"
+    end = re.compile(r"^\s*\[", re.MULTILINE).search(text, header.end())
+    end = len(text) if end is None else end.start()
+    # after the last key, blank lines and comments go with the next table
+    lines = text[header.end():end].split("\n")
+    while lines and (not lines[-1].strip() or lines[-1].lstrip().startswith("#")):
+        lines.pop()
+    body_end = header.end() + len("\n".join(lines))
+    return text[:body_end] + "\n" + line.rstrip("\n") + text[body_end:]
 
-class WeekRef:
-    @staticmethod
-    def group(timestamp):
-        year = timestamp.year
-        week = int(timestamp.strftime("%U"))
-        group_by = f"{year}-W{week:02d}"
-        return group_by
 
+def parse_timestamp(text: str) -> pd.Timestamp:
+    """Parse a date or timestamp given by the user."""
+    try:
+        timestamp = pd.Timestamp(text)
+    except (ValueError, TypeError, OverflowError):
+        timestamp = pd.NaT
+    if pd.isna(timestamp):
+        raise InvalidArgsError(
+            f"Invalid date {text!r}, use e.g. '2024-07-01' or '2024-07-01 09:00'."
+        )
+    return timestamp
 
-class YearRef:
-    @staticmethod
-    def group(timestamp):
-        year = timestamp.year
-        group_by = f"{year}"
-        return group_by
 
+def parse_range_end(text: str) -> pd.Timestamp:
+    """Parse the end of a range, a date alone includes that whole day."""
+    timestamp = parse_timestamp(text)
+    if ":" not in text:
+        timestamp = timestamp.normalize() + pd.Timedelta(days=1, microseconds=-1)
+    return timestamp
 
-class MonthRef:
-    @staticmethod
-    def group(timestamp):
-        year = timestamp.year
-        month = timestamp.month
-        group_by = f"{year}-M{month:02d}"
-        return group_by
 
+def parse_duration(text: str) -> pd.Timedelta:
+    """Parse durations like `90m`, `2h30m` or `1d`."""
+    text = str(text).strip()
+    parts = DURATION_PATTERN.findall(text)
+    if not parts or DURATION_PATTERN.sub("", text).strip():
+        raise InvalidArgsError(
+            f"Invalid duration {text!r}, use e.g. '45m', '2h30m' or '1d'."
+        )
+    return sum(
+        (pd.Timedelta(**{DURATION_UNITS[unit]: float(value)})
+         for value, unit in parts),
+        pd.Timedelta(0),
+    )
 
-class Aggregator:
-    def __init__(self, period: str = 'daily'):
-        self.period = period
-        if period == 'wtd':
-            self.time_agg = WeekRef.group
-        elif period == 'ytd':
-            self.time_agg = YearRef.group
-        elif period == 'mtd':
-            self.time_agg = MonthRef.group
-        elif period == 'daily':
-            self.time_agg = DailyRef.group
-        else:
-            raise ValueError(f"Period {period} not supported.")
 
-    @staticmethod
-    def infer_last_out(records):
-        if records[0][KIND] == "in":
-            new_record = {
-                KIND: "out",
-                TIMESTAMP: pd.Timestamp.now(),
-                NOTES: "Inferred by takt.",
-            }
-            records.insert(0, new_record)
-            msg = "NOTE: Last out was inferred using `Timestamp.now()`."
-            console.print(msg)
-        return records
+def backdated(at=None, ago=None) -> pd.Timestamp:
+    """Time of a check `--at` a time of today or a timestamp, or `--ago`."""
+    now = clock.now()
+    if at is not None and ago is not None:
+        raise InvalidArgsError("Use --at or --ago, not both.")
+    if ago is not None:
+        timestamp = now - parse_duration(ago)
+    elif at is None:
+        return now
+    elif re.fullmatch(r"\d{1,2}:\d{2}", at.strip()):
+        hour, minute = parse_clock(at)
+        timestamp = now.normalize() + pd.Timedelta(hours=hour, minutes=minute)
+    else:
+        timestamp = parse_timestamp(at)
+    if timestamp > now:
+        raise InvalidArgsError(f"{timestamp} is in the future.")
+    return timestamp
 
-    def calculate(self, records: list[dict]) -> list[dict]:
-        records = self.infer_last_out(records)
-        row_collection = []
-        last_in_time = None
-        last_out_time = None
 
-        for record in records:
-            # get variables
-            timestamp = record[TIMESTAMP]
+def parse_clock(text: str) -> tuple[int, int]:
+    """Parse a time of day like `18:30`."""
+    match = re.fullmatch(r"(\d{1,2}):(\d{2})", str(text).strip())
+    if not match or int(match[1]) > 23 or int(match[2]) > 59:
+        raise InvalidArgsError(f"Invalid time {text!r}, use e.g. '18:30'.")
+    return int(match[1]), int(match[2])
 
-            # update variables
-            if record[KIND] == 'in':
-                last_in_time = timestamp
-            else:
-                last_out_time = timestamp
 
-            if last_in_time and last_out_time:
-                group_by = self.time_agg(timestamp)
-                total_hours = (last_out_time - last_in_time).total_seconds() * SECONDS_TO_HOURS
-                date = timestamp.date()
-                note = record[NOTES]
+def notify(title: str, message: str):
+    """Show a desktop notification, fall back to the console."""
+    if platform.system() == "Darwin" and shutil.which("terminal-notifier"):
+        cmd = ["terminal-notifier", "-title", title, "-message", message]
+    elif platform.system() == "Darwin" and shutil.which("osascript"):
+        script = f"display notification {json.dumps(message)} with title {json.dumps(title)}"
+        cmd = ["osascript", "-e", script]
+    elif shutil.which("notify-send"):
+        cmd = ["notify-send", title, message]
+    else:
+        cmd = None
+    if cmd is None or subprocess.run(cmd, capture_output=True).returncode:
+        console.print(f"[bold]{title}:[/] {message}")
 
-                row = [group_by, total_hours, [date], [note]]
-                row_collection.append(row)
 
-                # reset variables
-                last_in_time = None
-                last_out_time = None
+ENV_VAR = re.compile(r"\$(?:\{(\w+)\}|(\w+))")
 
-        # aggregate to pandas
-        table = pd.DataFrame(row_collection, columns=['group', 'hours', 'dates', 'notes'])
-        summ = table.groupby('group').sum()
-        summ.sort_index(inplace=True, ascending=False)
-        summ.loc[:, "dates"] = summ.dates.apply(set)
-        summ.loc[:, "notes"] = summ.notes.apply(set)
-        summ.loc[:, "avg.hours"] = summ.hours / summ.dates.apply(len)
-        summ = summ.reset_index()
-        row_collection = summ.to_dict(orient='records')
-        return row_collection
 
+def expand_env(value, key=""):
+    """Expand `$VAR` and `${VAR}` in the strings of the config value `key`.
 
-def format_time_explicit(hours: float, hours_by_day=7.5) -> str:
-    d = int(hours / hours_by_day)
-    h = int((hours % hours_by_day) // 1)
-    m = int((hours % hours_by_day % 1) * 60)
-    if d > 0:
-        return f"{d:>02.0f} days{h:> 2.0f}H {m:> 3.0f} m"
-    return f"{h:> 10.0f} H{m:> 3.0f} m"
+    An unset variable is an error, left as is `${FEED_URL}` would be fetched
+    or sent somewhere as text.
+    """
+    if isinstance(value, str):
+        def replace(match):
+            name = match[1] or match[2]
+            if name not in os.environ:
+                raise ParseError(
+                    f"{key} uses ${name}, which is not set.",
+                    hint="Export it or remove it from the config.",
+                )
+            return os.environ[name]
+        return ENV_VAR.sub(replace, value)
+    if isinstance(value, list):
+        return [expand_env(v, key) for v in value]
+    if isinstance(value, dict):
+        return {
+            k: expand_env(v, f"{key}.{k}" if key else k) for k, v in value.items()
+        }
+    return value
 
 
-def format_time(hours: float) -> str:
-    h = str(int(hours)).zfill(2)
-    m = str(int((hours - int(hours)) * 60)).zfill(2)
-    return f"{h}:{m}"
+def merge_config(base: dict, override: dict) -> dict:
+    """Merge two configs, tables key by key and `override` winning."""
+    merged = dict(base)
+    for key, value in override.items():
+        if isinstance(value, dict) and isinstance(merged.get(key), dict):
+            value = merge_config(merged[key], value)
+        merged[key] = value
+    return merged
 
 
-class TableSummary:
-    # WIP
-    def __init__(self):
-        self.table = None
+def load_config(filename=CONFIG_FILE, _seen=()) -> dict:
+    """Return the user configuration, empty if there is no config file.
 
-    def build_table(self, records):
-        table = Table(show_header=True, header_style="bold magenta")
-        columns = [c for c in records[0].keys()]
-        for column in columns:
-            table.add_column(column, style="dim")
+    Environment variables in strings are expanded, except in `[hooks]`, and
+    the files listed in `include`, relative to the including one, are read
+    first so it can override them, e.g. team settings kept in the records
+    repository.
+    """
+    path = Path(filename).expanduser().resolve()
+    if not path.exists():
+        if _seen:
+            raise FileMissingError(f"Included config {path} does not exist.")
+        return {}
+    if path in _seen:
+        raise ParseError(f"Config {path} includes itself.")
+    try:
+        with open(path, 'rb') as f:
+            config = tomllib.load(f)
+    except tomllib.TOMLDecodeError as e:
+        raise ParseError(f"{path}: {e}") from e
+    try:
+        # hooks are shell commands, their variables are set when they run
+        config = {
+            k: v if k == "hooks" else expand_env(v, k) for k, v in config.items()
+        }
+    except ParseError as e:
+        raise ParseError(f"{path}: {e.message}", hint=e.hint) from e
+    includes = config.pop("include", [])
+    if isinstance(includes, str):
+        includes = [includes]
+    merged = {}
+    for include in includes:
+        include = path.parent / Path(include).expanduser()
+        merged = merge_config(merged, load_config(include, (*_seen, path)))
+    return merge_config(merged, config)
 
-        for row in records:
-            table.add_row(*row.values())
-        self.table = table
-        return table
 
-    def show(self):
-        console.print(self.table)
+def last_check(records):
+    """Return the newest record that is not a task timer nor leave."""
+    return next((r for r in records if r[KIND] not in SIDE_KINDS), None)
 
 
-def display_summary_table(summary_dict: list[dict], limit=10):
-    table = Table(show_header=True, header_style="bold magenta")
-    table.add_column("Date", style="dim")
-    table.add_column("Hours", style="dim")
-    table.add_column("N.Days", style="dim")  # Nueva columna
-    table.add_column("Avg Hours", style="dim")  # Nueva columna
+def easter(year) -> pd.Timestamp:
+    """Easter Sunday of the Gregorian calendar."""
+    a, b, c = year % 19, year // 100, year % 100
+    d, e = divmod(b, 4)
+    g = (8 * b + 13) // 25
+    h = (19 * a + b - d - g + 15) % 30
+    i, k = divmod(c, 4)
+    w = (32 + 2 * e + 2 * i - h - k) % 7
+    m = (a + 11 * h + 22 * w) // 451
+    month, day = divmod(h + w - 7 * m + 114, 31)
+    return pd.Timestamp(year, month, day + 1)
 
-    for i, row in enumerate(summary_dict):
-        day = row['group']
-        total_hours = row['hours']
-        dates = row['dates']
-        nobs = len(dates)
 
-        total_hours_str = format_time(total_hours)
-        avg_hours = total_hours / nobs if nobs else 0  # Media de horas
-        avg_hours_str = format_time(avg_hours)
+def public_holidays(country: str, year: int, region=None) -> dict:
+    """Public holidays of a country, by date.
 
-        table.add_row(day, total_hours_str, str(nobs), avg_hours_str)
-        if i >= limit:
-            break
+    Uses the `holidays` package when it is installed, which knows regions
+    and many more countries, else the national holidays built in takt.
+    """
+    try:
+        import holidays
+    except ImportError:
+        holidays = None
+    if holidays is not None:
+        try:
+            return dict(holidays.country_holidays(country, subdiv=region, years=year))
+        except NotImplementedError:
+            raise InvalidArgsError(f"No holidays known for {country}.")
+    if country not in HOLIDAYS:
+        raise InvalidArgsError(
+            f"No holidays built in for {country}, install the `holidays` "
+            f"package or use one of {', '.join(HOLIDAYS)}."
+        )
+    fixed, movable = HOLIDAYS[country]
+    days = {pd.Timestamp(year, month, day).date(): name for month, day, name in fixed}
+    sunday = easter(year)
+    for offset, name in movable:
+        days[(sunday + pd.Timedelta(days=offset)).date()] = name
+    return days
 
-    console.print(table)
 
+def leave_days(records) -> dict:
+    """Leave type and credited time of each day off.
 
-def strip_values(df: pd.DataFrame) -> pd.DataFrame:
-    df.columns = df.columns.str.strip()
-    for c in df.columns:
-        try:
-            df[c] = df[c].str.strip()
-        except AttributeError:
-            pass
-    return df
+    Half days off are labelled with their half, `vacation am`, and a day
+    with both halves off adds up their time.
+    """
+    days = {}
+    for record in records:
+        if record[KIND] != LEAVE:
+            continue
+        meta = parse_meta(record.get(META))
+        hours = parse_duration(meta.get("hours", DAILY_TARGET))
+        label = meta.get("leave", "vacation")
+        if meta.get("half"):
+            label = f"{label} {meta['half']}"
+        day = record[TIMESTAMP].date()
+        if day in days:
+            other, other_hours = days[day]
+            label, hours = f"{other}/{label}", other_hours + hours
+        days[day] = (label, hours)
+    return days
 
 
-class Takt:
-    """
-    Interface for managing and processing data records, primarily focused on
-    file system interactions and data aggregation.
+def leave_halves(records) -> dict:
+    """Halves, `am`, `pm` or both for a whole day, taken off each day."""
+    days = {}
+    for record in records:
+        if record[KIND] != LEAVE:
+            continue
+        half = parse_meta(record.get(META)).get("half")
+        days.setdefault(record[TIMESTAMP].date(), set()).update(
+            [half] if half else LEAVE_HALVES
+        )
+    return days
 
-    This class encapsulates functionalities for file management, data
-    retrieval, insertion, and aggregation. It is designed to provide a
-    structured and extendable approach to handling file-based data records.
 
-    Attributes
-    ----------
-    row : FileRow
-        Class representing a row in the data file.
-    filename : str
-        Name of the file to manage.
-    _file_manager : FileManager, optional
-        Internal manager for file operations.
-    _aggregator : Aggregator, optional
-        Internal tool for data aggregation.
+def task_of(record) -> str:
+    return parse_meta(record.get(META)).get("task")
 
-    Methods
-    -------
-    file_manager()
-        Accessor for the FileManager instance, lazily initialized.
-    all_rows(nrows=None)
-        Retrieves a list of data records from the file, optionally limited to `nrows`.
-    insert_row(timestamp, kind, notes)
-        Inserts a new row into the file with the given data.
-    first_row()
-        Returns the first data record from the file.
-    aggregate(period="daily")
-        Aggregates data records based on the specified period.
-    register(*args, plugin_name=None, **kwargs)
-        Static method. Registers a new command with an optional plugin name.
-    print_console(msg, style=None)
-        Static method. Prints a message to the console with optional styling.
 
-    Examples
-    --------
-    >>> takt = Takt()
-    >>> takt.insert_row("2023-01-01", "example", "This is a note.")
-    >>> takt.print_console(takt_instance.first_row())
+def running_tasks(records) -> dict:
+    """Return the start of every running task timer."""
+    latest = {}
+    for record in records:
+        if record[KIND] in TASK_KINDS:
+            latest.setdefault(task_of(record), record)
+    return {
+        task: record[TIMESTAMP]
+        for task, record in latest.items()
+        if record[KIND] == TASK_START
+    }
 
-    Notes
-    -----
-    The class is intended to be used in environments where structured
-    file-based data management is required. It is particularly useful for
-    scenarios involving data aggregation and processing.
-    """
 
+def task_durations(records, now) -> dict:
+    """Return the time and number of timers of every task."""
+    stops = {}
+    totals = {}
+    for record in records:
+        if record[KIND] not in TASK_KINDS:
+            continue
+        task = task_of(record)
+        if record[KIND] == TASK_STOP:
+            stops[task] = record[TIMESTAMP]
+            continue
+        end = stops.pop(task, now)
+        duration, count = totals.get(task, (pd.Timedelta(0), 0))
+        totals[task] = (duration + end - record[TIMESTAMP], count + 1)
+    return totals
 
-    def __init__(self):
-        self.row = FileRow
-        self.filename = FILE_NAME
-        self._file_manager = None
-        self._aggregator = None
 
-    @property
-    def file_manager(self):
-        """Get File manager."""
-        file_manager = self._file_manager
-        if file_manager is None:
-            file_manager = FileManager(self.filename)
-            self._file_manager = file_manager
-        return file_manager
+def records_encoding(head: bytes) -> str:
+    """Encoding of a records file from its first bytes, Excel adds BOMs."""
+    if head.startswith((codecs.BOM_UTF16_LE, codecs.BOM_UTF16_BE)):
+        return "utf-16"
+    return "utf-8-sig"
 
-    def all_rows(self, nrows=None) -> list[dict[str, float | str]]:
-        """Return records from file."""
-        file_manager = self.file_manager
-        return file_manager.load(nrows=nrows)
 
-    def insert_row(self, timestamp, kind, notes):
-        """Inser row in file."""
-        file_manager = self.file_manager
-        file_manager.insert(**self.row(timestamp, kind, notes))
+def decode_records(raw: bytes) -> str:
+    """Decode a records file with universal newlines."""
+    try:
+        text = raw.decode(records_encoding(raw[:4]))
+    except UnicodeDecodeError:
+        # saved by Excel with the Windows code page
+        text = raw.decode("cp1252", errors="replace")
+    return text.replace("\r\n", "\n").replace("\r", "\n")
 
-    def first_row(self):
-        """Return first row from file."""
-        file_manager = self.file_manager
-        return file_manager.first()
 
-    def aggregate(self, period: str = "daily") -> list[dict]:
-        """Aggregate records."""
-        aggregator = Aggregator(period)
-        records = self.all_rows()
-        return aggregator.calculate(records)
+def cp1252_fallback(error):
+    """Decode the bytes invalid in utf-8 as cp1252, how Excel saves them."""
+    bad = error.object[error.start:error.end]
+    return bad.decode("cp1252", errors="replace"), error.end
 
-    @staticmethod
-    def register(*args, plugin_name=None, **kwargs):
-        """Decorator to register a new Takt command.
 
-        It uses the typer command decorator. So use the same signature. This
-        means that you can use this decorator to register a new command in
-        typer as well.
-        """
-        if plugin_name is None:
-            raise ValueError("plugin_name must be provided.")
-        # ensure rich help panel is not duplicated
-        kwargs = {**kwargs, "rich_help_panel": plugin_name}
-        return app.command(*args, **kwargs)
+codecs.register_error("takt-cp1252", cp1252_fallback)
 
-    @staticmethod
-    def print_console(msg, style=None):
-        """Print message to console."""
-        console.print(msg, style=style)
 
+def iter_rows(f):
+    """Yield (line, row) from the binary records file `f`, one row at a time.
 
-@app.command()
-def check(notes: str = ""):
+    Values are stripped, missing ones are empty and extra ones are listed
+    under the None key. `line` is where the row starts, the header is line 1.
     """
-    Check in or out.
+    encoding = records_encoding(f.read(4))
+    f.seek(0)
+    lines = io.TextIOWrapper(f, encoding=encoding, errors="takt-cp1252")
+    header = lines.readline()
+    delimiter = records_delimiter(header)
+    fieldnames = next(csv.reader([header], delimiter=delimiter), [])
+    reader = csv.DictReader(
+        lines,
+        fieldnames=[name.strip() for name in fieldnames],
+        restval="",
+        delimiter=delimiter,
+        skipinitialspace=True,
+    )
+    line = 1
+    for row in reader:
+        yield line + 1, {
+            k: v.strip() if isinstance(v, str) else v for k, v in row.items()
+        }
+        line = reader.line_num + 1
+
+
+def parse_legacy_timestamp(value: str):
+    """Parse a timestamp in one of the `LEGACY_TIMESTAMP` forms, else None.
+
+    `2023-11-09 10:15`, `2023-11-09 10:15:00.000000` or `2023/11/09 9:15:00,5`
+    all read the same as ISO 8601 and are written back as such.
+    """
+    match = LEGACY_TIMESTAMP.fullmatch(value)
+    if match is None:
+        return None
+    year, month, day, hour, minute, second, fraction = match.groups()
+    return pd.Timestamp(
+        year=int(year), month=int(month), day=int(day),
+        hour=int(hour), minute=int(minute), second=int(second or 0),
+        microsecond=int((fraction or "0")[:6].ljust(6, "0")),
+    )
+
+
+def records_delimiter(header: str) -> str:
+    """Excel saves `;` or tab separated files depending on the locale."""
+    for delimiter in (",", "\t", ";"):
+        if delimiter in header:
+            return delimiter
+    return ","
+
+
+def records_to_csv(records, columns) -> str:
+    """Serialize records, notes with commas, quotes or newlines are quoted."""
+    out = io.StringIO()
+    writer = csv.DictWriter(
+        out, columns, extrasaction="ignore", lineterminator="\n"
+    )
+    writer.writeheader()
+    for record in records:
+        writer.writerow({
+            c: "" if pd.isna(record.get(c)) else str(record.get(c))
+            for c in columns
+        })
+    return out.getvalue()
+
+
+def unmatched_checks(records) -> int:
+    """Count the check ins and outs without their pair, newest first input."""
+    unmatched, expected = 0, "in"
+    for record in reversed(records):
+        if record[KIND] not in ("in", "out"):
+            continue
+        if record[KIND] != expected:
+            unmatched += 1
+        expected = "out" if record[KIND] == "in" else "in"
+    return unmatched
+
+
+def parse_meta(text: str) -> dict:
+    """Parse `key=value;key=value` metadata."""
+    pairs = (item.partition("=") for item in (text or "").split(";") if item)
+    return {key.strip(): value.strip() for key, _, value in pairs}
+
+
+def format_meta(meta: dict) -> str:
+    return ";".join(f"{key}={value}" for key, value in meta.items() if value)
+
+
+def coworkers_of(meta: str) -> list[str]:
+    """Co-workers of a check in, stored as `with=ana,bob` in its meta."""
+    names = parse_meta(meta).get("with", "").split(",")
+    return [name.strip() for name in names if name.strip()]
+
+
+def active_profile(config: dict):
+    """Return the profile in use, None for the default records file."""
+    if state["profile"]:
+        return state["profile"]
+    if os.getenv("TAKT_PROFILE"):
+        return os.getenv("TAKT_PROFILE")
+    if Path(PROFILE_FILE).exists():
+        return Path(PROFILE_FILE).read_text().strip() or None
+    return config.get("default_profile")
+
+
+def records_file(config: dict = None) -> str:
+    """Return the records file of the active profile."""
+    config = load_config() if config is None else config
+    profile = active_profile(config)
+    if profile is None:
+        return FILE_NAME
+    profiles = config.get("profiles", {})
+    if profile not in profiles:
+        raise InvalidArgsError(
+            f"Profile {profile} not found in {CONFIG_FILE}, "
+            f"available: {', '.join(profiles) or 'none'}."
+        )
+    return os.path.expanduser(profiles[profile]["file"])
+
+
+def record_identity(record) -> tuple:
+    """What tells a record apart from others, its position can change."""
+    return record[TIMESTAMP], record[KIND], record[NOTES]
+
+
+class FileRow(dict):
+    def __init__(self, timestamp, kind, notes, meta=""):
+        super().__init__(timestamp=timestamp, kind=kind, notes=notes, meta=meta)
+
+
+class TaktError(Exception):
+    """Base error, the message is shown to the user on stderr.
+
+    `hint` tells how to fix it and `line` is the line of the records file at
+    fault, both are reported apart with `--json`.
+    """
+    exit_code = EXIT_ERROR
+    code = "error"
+
+    def __init__(self, message="", hint=None, line=None):
+        super().__init__(message)
+        self.message = message
+        self.hint = hint
+        self.line = line
+
+    def __str__(self):
+        return f"{self.message} {self.hint}" if self.hint else self.message
+
+    def to_dict(self) -> dict:
+        return {
+            "code": self.code,
+            "message": self.message,
+            "hint": self.hint,
+            "line": self.line,
+        }
+
+
+class InvalidArgsError(TaktError, ValueError):
+    exit_code = EXIT_INVALID_ARGS
+    code = "invalid_args"
+
+
+class FileMissingError(TaktError):
+    exit_code = EXIT_FILE_MISSING
+    code = "file_missing"
+
+
+class ParseError(TaktError, ValueError):
+    exit_code = EXIT_PARSE_ERROR
+    code = "parse_error"
+
+
+class GitError(TaktError):
+    exit_code = EXIT_GIT_ERROR
+    code = "git_error"
+
+
+class LockError(TaktError):
+    exit_code = EXIT_LOCK_ERROR
+    code = "lock_error"
+
+
+class FileLock:
+    """Advisory lock file held while the records file is rewritten.
+
+    The lock file stores the pid of its owner, a lock whose owner is no longer
+    running is considered stale and removed. It is reentrant within a
+    thread, nested writes don't wait for themselves, while other threads,
+    like the requests of `takt serve`, wait as other processes do.
+    """
+
+    # nesting depth by (lock path, thread)
+    held = Counter()
+
+    def __init__(self, filename, timeout=LOCK_TIMEOUT):
+        self.path = os.path.abspath(f"{filename}.lock")
+        self.timeout = timeout
+
+    @property
+    def key(self):
+        return self.path, threading.get_ident()
+
+    def __enter__(self):
+        if self.held[self.key]:
+            self.held[self.key] += 1
+            return self
+        deadline = time.monotonic() + self.timeout
+        while True:
+            try:
+                fd = os.open(self.path, os.O_CREAT | os.O_EXCL | os.O_WRONLY)
+            except FileExistsError:
+                if self.is_stale():
+                    err_console.print(f"Removing stale lock {self.path}.")
+                    self.release()
+                    continue
+                if time.monotonic() > deadline:
+                    raise LockError(
+                        f"{self.path} is held by another takt process.",
+                        hint="Remove it if no takt is running or use "
+                        "`--no-lock`.",
+                    )
+                time.sleep(0.1)
+                continue
+            with os.fdopen(fd, 'w') as f:
+                f.write(str(os.getpid()))
+            self.held[self.key] = 1
+            return self
+
+    def __exit__(self, *exc):
+        self.held[self.key] -= 1
+        if not self.held[self.key]:
+            del self.held[self.key]
+            self.release()
+
+    def release(self):
+        try:
+            os.remove(self.path)
+        except FileNotFoundError:
+            pass
+
+    def is_stale(self):
+        try:
+            with open(self.path) as f:
+                pid = int(f.read().strip())
+        except FileNotFoundError:
+            return False
+        except ValueError:
+            # owner may still be writing its pid
+            age = time.time() - os.path.getmtime(self.path)
+            return age > self.timeout
+        try:
+            os.kill(pid, 0)
+        except ProcessLookupError:
+            return True
+        except PermissionError:
+            return False
+        return False
+
+
+class Journal:
+    """Append-only log of every change made to a records file.
+
+    Each line is an event adding or removing one record, events written
+    together share a transaction id. The records file is a snapshot that can
+    be rebuilt by replaying the journal.
+    """
+
+    def __init__(self, filename):
+        self.path = f"{filename}.journal.jsonl"
+
+    @staticmethod
+    def key(record):
+        record = {c: record.get(c, '') for c in COLUMNS + EXTENDED_COLUMNS}
+        if not isinstance(record[TIMESTAMP], str):
+            record[TIMESTAMP] = record[TIMESTAMP].isoformat()
+        return json.dumps(record, sort_keys=True)
+
+    def exists(self):
+        return Path(self.path).exists()
+
+    def events(self):
+        if not self.exists():
+            return []
+        with open(self.path) as f:
+            return [json.loads(line) for line in f if line.strip()]
+
+    def append(self, added, removed, undo=None, sync=False):
+        """Log a transaction, return its id or None if nothing changed.
+
+        `sync` transactions only catch up with the records file and can't be
+        undone.
+        """
+        if not added and not removed:
+            return None
+        tx = uuid.uuid4().hex
+        at = clock.now().isoformat()
+        events = [("remove", key) for key in removed]
+        events += [("add", key) for key in added]
+        with open(self.path, 'a') as f:
+            for op, key in events:
+                event = {"tx": tx, "at": at, "op": op, "record": json.loads(key)}
+                if undo is not None:
+                    event["undo"] = undo
+                if sync:
+                    event["sync"] = True
+                f.write(json.dumps(event) + "\n")
+            f.flush()
+            os.fsync(f.fileno())
+        return tx
+
+    def record(self, old, new, sync=False):
+        """Log the difference between two lists of records."""
+        old = Counter(self.key(r) for r in old)
+        new = Counter(self.key(r) for r in new)
+        added = list((new - old).elements())
+        removed = list((old - new).elements())
+        return self.append(added, removed, sync=sync)
+
+    @staticmethod
+    def apply(keys: Counter, events) -> Counter:
+        for event in events:
+            key = json.dumps(event["record"], sort_keys=True)
+            keys[key] += 1 if event["op"] == "add" else -1
+        return keys
+
+    @staticmethod
+    def records(keys: Counter):
+        """Return the records of a counter of keys, newest first."""
+        records = []
+        for key in (+keys).elements():
+            record = json.loads(key)
+            record[TIMESTAMP] = pd.Timestamp(record[TIMESTAMP])
+            records.append(record)
+        return sorted(records, key=lambda r: r[TIMESTAMP], reverse=True)
+
+    def replay(self):
+        """Return the records resulting from all the events."""
+        return self.records(self.apply(Counter(), self.events()))
+
+    def compact(self, cutoff) -> int:
+        """Fold the events before `cutoff` into one checkpoint transaction.
+
+        Replaying gives the same records, but the folded changes can't be
+        undone anymore. Return how many events were dropped.
+        """
+        events = self.events()
+        old = [e for e in events if pd.Timestamp(e["at"]) < cutoff]
+        recent = [e for e in events if pd.Timestamp(e["at"]) >= cutoff]
+        base = [self.key(r) for r in self.records(self.apply(Counter(), old))]
+        # only adds, e.g. checks, are already as short as they get
+        if len(base) >= len(old):
+            return 0
+        tx, at = uuid.uuid4().hex, old[-1]["at"]
+        checkpoint = [
+            {"tx": tx, "at": at, "op": "add", "record": json.loads(key),
+             "sync": True}
+            for key in base
+        ]
+        write_atomic(self.path, "".join(
+            json.dumps(event) + "\n" for event in checkpoint + recent
+        ))
+        return len(old) - len(checkpoint)
+
+    def last_transaction(self):
+        """Events of the newest transaction that was not undone."""
+        events = self.events()
+        undone = {e["undo"] for e in events if "undo" in e}
+        for event in reversed(events):
+            tx = event["tx"]
+            undoable = "undo" not in event and "sync" not in event
+            if undoable and tx not in undone:
+                return [e for e in events if e["tx"] == tx]
+        return []
+
+
+def month_offsets(raw: bytes) -> list[list] | None:
+    """Where the records of each month end in `raw`, newest month first.
+
+    Each entry is `[month, offset, kind]`, the bytes up to `offset` hold that
+    month and the newer ones, and `kind` is the kind of the oldest of them.
+    None if the file can't be sliced: not utf-8 or not sorted newest first.
+    """
+    if records_encoding(raw[:4]) != "utf-8-sig" or not raw.strip():
+        return None
+    consumed = 0
+
+    def lines():
+        nonlocal consumed
+        for line in raw.splitlines(keepends=True):
+            consumed += len(line)
+            yield line.decode("utf-8-sig", "replace")
+
+    reader = csv.reader(lines(), delimiter=records_delimiter(
+        raw.splitlines()[0].decode("utf-8-sig", "replace")
+    ))
+    header = [name.strip() for name in next(reader)]
+    if TIMESTAMP not in header or KIND not in header:
+        return None
+    at, kind_at = header.index(TIMESTAMP), header.index(KIND)
+    months, end, last_kind = [], consumed, None
+    for row in reader:
+        if not row:
+            continue
+        start = end
+        end = consumed
+        timestamp = row[at] if len(row) > at else ""
+        match = re.match(r"\s*(\d{4}-\d{2})", timestamp)
+        if match is None:
+            return None
+        month = match.group(1)
+        if months and month != months[-1][0]:
+            if month > months[-1][0] or any(m == month for m, _, _ in months):
+                return None
+            months[-1][1:] = [start, last_kind]
+        if not months or month != months[-1][0]:
+            months.append([month, None, None])
+        last_kind = row[kind_at].strip() if len(row) > kind_at else ""
+    if months:
+        months[-1][1:] = [end, last_kind]
+    return months
+
+
+class RecordIndex:
+    """Byte offsets of the month boundaries of a records file.
+
+    The file is newest first, so the last months are its first bytes and a
+    summary of them doesn't need the rest. The index lives in the state dir,
+    it is updated on every write and rebuilt when the file was changed
+    outside takt, its size and mtime tell. It also stamps the last write
+    logged in the journal, see `journaled`.
+    """
+
+    def __init__(self, filename):
+        self.filename = os.path.abspath(filename)
+        digest = hashlib.sha256(self.filename.encode()).hexdigest()[:16]
+        self.path = os.path.join(INDEX_DIR, f"{digest}.json")
+
+    def read(self) -> dict | None:
+        try:
+            return json.loads(Path(self.path).read_text())
+        except (OSError, ValueError):
+            return None
+
+    def update(self, raw: bytes = None, journal: str = None) -> dict | None:
+        """Index the file as it is on disk, `raw` being its content.
+
+        With the `journal` path it was just written, see `journaled`.
+        """
+        stat = os.stat(self.filename)
+        if raw is None:
+            raw = Path(self.filename).read_bytes()
+            if os.stat(self.filename).st_mtime_ns != stat.st_mtime_ns:
+                # written meanwhile, the next read indexes it
+                return None
+        data = {
+            "file": self.filename,
+            "size": stat.st_size,
+            "mtime_ns": stat.st_mtime_ns,
+            "months": month_offsets(raw),
+        }
+        if journal is not None:
+            data["journaled"] = self.stamp(journal)
+        elif "journaled" in (old := self.read() or {}):
+            data["journaled"] = old["journaled"]
+        os.makedirs(INDEX_DIR, exist_ok=True)
+        write_atomic(self.path, json.dumps(data) + "\n")
+        return data
+
+    def stamp(self, journal) -> list[int]:
+        stat = os.stat(self.filename)
+        size = os.path.getsize(journal) if os.path.exists(journal) else 0
+        return [stat.st_size, stat.st_mtime_ns, size]
+
+    def journaled(self, journal) -> bool:
+        """Whether the file and its `journal` are as a takt write left them.
+
+        Otherwise the file was edited outside takt, or a write failed after
+        the journal, and the journal has to catch up.
+        """
+        data = self.read()
+        try:
+            return data is not None and data.get("journaled") == self.stamp(journal)
+        except OSError:
+            return False
+
+    def months(self) -> list[list] | None:
+        """Entries of `month_offsets` for the file on disk."""
+        stat = os.stat(self.filename)
+        data = self.read()
+        if data is None or (data["size"], data["mtime_ns"]) != (
+            stat.st_size, stat.st_mtime_ns
+        ):
+            data = self.update()
+        return None if data is None else data["months"]
+
+
+class FileManager:
+    columns = COLUMNS
+
+    def __init__(
+        self, filename, lenient=False, lock=True, backup=None, journal=True
+    ):
+        self.filename = filename
+        self.lenient = lenient
+        self.use_lock = lock
+        # backup settings, see `[backup]` in the config
+        self.backup_config = {} if backup is None else backup
+        self.journal = Journal(filename) if journal else None
+        self.unsorted = False
+        self.warned_unsorted = False
+        # Excel in Europe saves timestamps back like 02/11/2023 09:00
+        self.dayfirst = False
+
+    def read(self):
+        if not self.exists():
+            raise FileMissingError(f"File {self.filename} does not exist.")
+        with trace.phase("read"), open(self.filename, 'rb') as f:
+            text = decode_records(f.read())
+        with trace.phase("parse"):
+            data = self.parse(text)
+        trace.count("parse", len(data))
+        return data
+
+    def parse(self, text):
+        """Records of the decoded `text`, newest first."""
+        if not text.strip():
+            return pd.DataFrame(columns=self.columns + EXTENDED_COLUMNS)
+        try:
+            data = pd.read_csv(
+                io.StringIO(text),
+                dtype=str,
+                keep_default_na=False,
+                sep=records_delimiter(text.partition("\n")[0]),
+                on_bad_lines="warn" if self.lenient else "error",
+                # older takt ended lines with a comma, not a first index column
+                index_col=False,
+            )
+        except pd.errors.ParserError as e:
+            raise ParseError(f"{self.filename}: {e}") from e
+        # clean trailing spaces
+        data = strip_values(data)
+        missing = [c for c in self.columns if c not in data.columns]
+        if missing:
+            raise ParseError(
+                f"{self.filename}: missing column(s) {', '.join(missing)}."
+            )
+        if data.empty:
+            return data
+        for column in EXTENDED_COLUMNS:
+            if column not in data.columns:
+                data[column] = ''
+        data = data[self.columns + EXTENDED_COLUMNS]
+        data.fillna('', inplace=True)
+        data = data.replace({NOTES: dict.fromkeys(LEGACY_NAN, '')})
+        data.timestamp = self.parse_timestamps(data.timestamp)
+        data = data[self.has_kind(data[KIND])]
+        data = data.dropna(subset=[TIMESTAMP])
+        # everything else expects the newest record first
+        self.unsorted = not data[TIMESTAMP].is_monotonic_decreasing
+        if self.unsorted:
+            if not self.warned_unsorted:
+                err_console.print(
+                    f"[yellow]WARNING:[/] {self.filename} is not sorted newest "
+                    "first, run `takt sort` to fix it."
+                )
+                self.warned_unsorted = True
+            data = data.sort_values(TIMESTAMP, ascending=False, kind="stable")
+        return data
+
+    def iter_records(self, lines=False):
+        """Yield the records in file order, reading no more than consumed.
+
+        Unlike `read`, nothing is sorted: it relies on the file being newest
+        first, as takt writes it. With `lines` it yields `(line, record)`.
+        """
+        if not self.exists():
+            raise FileMissingError(f"File {self.filename} does not exist.")
+        with open(self.filename, 'rb') as f:
+            for line, row in iter_rows(f):
+                missing = [c for c in self.columns if c not in row]
+                if missing:
+                    raise ParseError(
+                        f"{self.filename}: missing column(s) "
+                        f"{', '.join(missing)}."
+                    )
+                # older takt ended lines with a comma, an empty extra field
+                if None in row and not any(v.strip() for v in row[None]):
+                    del row[None]
+                if None in row:
+                    msg = f"{self.filename}:{line}: too many fields."
+                    if not self.lenient:
+                        raise ParseError(msg, line=line)
+                    err_console.print(f"[yellow]WARNING:[/] {msg} Line skipped.")
+                    continue
+                if not row[KIND]:
+                    msg = f"{self.filename}:{line}: record without {KIND}."
+                    if not self.lenient:
+                        raise ParseError(msg, line=line)
+                    err_console.print(f"[yellow]WARNING:[/] {msg} Line skipped.")
+                    continue
+                timestamp = self.parse_timestamp(row[TIMESTAMP], line)
+                if timestamp is None:
+                    continue
+                columns = self.columns + EXTENDED_COLUMNS
+                record = {c: row.get(c, "") for c in columns}
+                record[TIMESTAMP] = timestamp
+                if record[NOTES] in LEGACY_NAN:
+                    record[NOTES] = ""
+                yield (line, record) if lines else record
+
+    def head(self, n) -> list[dict]:
+        """Return the first `n` valid records, stopping reading there."""
+        with trace.phase("read"):
+            records = list(itertools.islice(self.iter_records(), n))
+        trace.count("read", len(records))
+        return records
+
+    def parse_timestamp(self, value, line):
+        """Parse the timestamp at `line`, None if invalid and lenient.
+
+        A time without date is invalid, pandas would read it as today.
+        """
+        try:
+            legacy = parse_legacy_timestamp(str(value))
+            if legacy is not None:
+                timestamp = legacy
+            elif TIME_ONLY.fullmatch(str(value)):
+                timestamp = pd.NaT
+            elif self.dayfirst and not ISO_TIMESTAMP.match(str(value)):
+                timestamp = pd.to_datetime(value, dayfirst=True)
+            else:
+                timestamp = pd.Timestamp(value)
+        except (ValueError, TypeError, OverflowError):
+            timestamp = pd.NaT
+        if not pd.isna(timestamp):
+            return timestamp
+        msg = f"{self.filename}:{line}: invalid timestamp {value!r}."
+        if not self.lenient:
+            raise ParseError(msg, hint=TIMESTAMP_HINT, line=line)
+        err_console.print(f"[yellow]WARNING:[/] {msg} Line skipped.")
+        return None
+
+    def parse_timestamps(self, values):
+        parsed = []
+        for i, value in values.items():
+            # header is line 1
+            timestamp = self.parse_timestamp(value, i + 2)
+            parsed.append(pd.NaT if timestamp is None else timestamp)
+        return pd.Series(parsed, index=values.index, dtype="datetime64[ns]")
+
+    def has_kind(self, kinds):
+        """Fail, or warn when lenient, on short lines without kind."""
+        missing = kinds == ''
+        for i in kinds.index[missing]:
+            msg = f"{self.filename}:{i + 2}: record without {KIND}."
+            if not self.lenient:
+                raise ParseError(msg, line=i + 2)
+            err_console.print(f"[yellow]WARNING:[/] {msg} Line skipped.")
+        return ~missing
+
+    def exists(self, create=True):
+        if Path(self.filename).exists():
+            return True
+        if create:
+            pd.DataFrame(columns=self.columns).to_csv(
+                self.filename, index=False
+            )
+            return True
+        return False
+
+    def load(self, nrows=None) -> list[dict[str, float | str]]:
+        if nrows is not None:
+            return self.head(nrows)
+        return self.read().to_dict('records')
+
+    def load_recent(self, months: int) -> list[dict] | None:
+        """Records of the newest `months` months with records, or more.
+
+        Only that slice of the file is read, see `RecordIndex`, and it ends
+        at a check in so no session is cut. None if the slice would be the
+        whole file or there is no usable index, read everything then.
+        """
+        if not self.exists(create=False):
+            return None
+        try:
+            index = RecordIndex(self.filename).months()
+        except OSError:
+            return None
+        ends = [
+            end for _, end, kind in (index or [])[months - 1:-1] if kind == 'in'
+        ]
+        if not ends:
+            return None
+        with trace.phase("read"), open(self.filename, 'rb') as f:
+            text = decode_records(f.read(ends[0]))
+        with trace.phase("parse"):
+            data = self.parse(text)
+        trace.count("parse", len(data))
+        return data.to_dict('records')
+
+    def archive_path(self, year):
+        path = Path(self.filename)
+        return str(path.with_name(f"{path.stem}-{year}{path.suffix}"))
+
+    def archive_managers(self):
+        """File managers of the yearly archives, newest first."""
+        path = Path(self.filename)
+        pattern = re.compile(rf"{re.escape(path.stem)}-(\d{{4}}){re.escape(path.suffix)}")
+        years = sorted(
+            (int(m.group(1)) for p in path.parent.glob(f"{path.stem}-*{path.suffix}")
+             if (m := pattern.fullmatch(p.name))),
+            reverse=True,
+        )
+        return [
+            FileManager(
+                self.archive_path(year),
+                self.lenient,
+                self.use_lock,
+                self.backup_config,
+                self.journal is not None,
+            )
+            for year in years
+        ]
+
+    def load_all(self):
+        """Load the records and all the archives, newest first."""
+        records = self.load()
+        for archive in self.archive_managers():
+            records.extend(archive.load())
+        return records
+
+    def archive(self, cutoff):
+        """Move records older than `cutoff` to yearly archives.
+
+        Return the number of archived records by year.
+        """
+        with self.lock():
+            records = self.load()
+            keep = [r for r in records if r[TIMESTAMP] >= cutoff]
+            old = [r for r in records if r[TIMESTAMP] < cutoff]
+            by_year = {}
+            for record in old:
+                by_year.setdefault(record[TIMESTAMP].year, []).append(record)
+            for year, year_records in by_year.items():
+                archive = FileManager(
+                    self.archive_path(year),
+                    self.lenient,
+                    backup=self.backup_config,
+                    journal=self.journal is not None,
+                )
+                archive.exists(create=True)
+                merged = sorted(
+                    archive.load() + year_records,
+                    key=lambda r: r[TIMESTAMP],
+                    reverse=True,
+                )
+                archive.save(merged)
+            if old:
+                self.save(keep)
+        return {year: len(rs) for year, rs in by_year.items()}
+
+    def save(self, records, backup=True):
+        if backup and self.backup_config.get("enabled", True):
+            self.backup()
+        if self.journal is not None:
+            old = self.load() if Path(self.filename).exists() else []
+            if not RecordIndex(self.filename).journaled(self.journal.path):
+                # catch up with edits made outside takt, e.g. `takt edit`
+                self.journal.record(self.journal.replay(), old, sync=True)
+            self.journal.record(old, records)
+        self.save_snapshot(records, journaled=self.journal is not None)
+
+    def save_snapshot(self, records, journaled=False):
+        """Write the records file without backup nor journal.
+
+        `journaled` tells the journal has every change of `records`, so the
+        next save doesn't replay it to catch up.
+        """
+        columns = self.columns + [
+            c for c in EXTENDED_COLUMNS if any(r.get(c) for r in records)
+        ]
+        text = records_to_csv(records, columns)
+
+        def verify(path):
+            with open(path, 'rb') as f:
+                rows = sum(1 for _ in iter_rows(f))
+            if rows != len(records):
+                raise TaktError(
+                    f"{self.filename} not written, {rows} of {len(records)} "
+                    "records read back.",
+                    hint="The file is untouched, report it with the records.",
+                )
+
+        write_atomic(self.filename, text, verify)
+        try:
+            RecordIndex(self.filename).update(
+                text.encode(), self.journal.path if journaled else None
+            )
+        except OSError:
+            # only a cache, summaries read the whole file without it
+            pass
+
+    def backup_prefix(self):
+        path = Path(self.filename).resolve()
+        digest = hashlib.sha256(str(path).encode()).hexdigest()[:8]
+        return f"{path.stem}.{digest}."
+
+    def backups(self):
+        """Backups of the records file, newest first."""
+        backup_dir = Path(self.backup_config.get("dir", BACKUP_DIR)).expanduser()
+        if not backup_dir.exists():
+            return []
+        prefix = self.backup_prefix()
+        return sorted(
+            (p for p in backup_dir.iterdir() if p.name.startswith(prefix)),
+            reverse=True,
+        )
+
+    def backup(self):
+        """Copy the records file to the backups dir, return the copy's path."""
+        if not Path(self.filename).exists():
+            return None
+        backup_dir = Path(self.backup_config.get("dir", BACKUP_DIR)).expanduser()
+        backup_dir.mkdir(parents=True, exist_ok=True)
+        stamp = clock.now().strftime("%Y%m%d-%H%M%S%f")
+        path = backup_dir / f"{self.backup_prefix()}{stamp}{Path(self.filename).suffix}"
+        shutil.copy2(self.filename, path)
+        self.prune_backups()
+        return str(path)
+
+    def prune_backups(self):
+        """Remove backups beyond the configured count or age."""
+        keep = self.backup_config.get("keep", BACKUP_KEEP)
+        days = self.backup_config.get("days")
+        now = time.time()
+        for i, path in enumerate(self.backups()):
+            too_many = keep is not None and i >= keep
+            too_old = days is not None and now - path.stat().st_mtime > days * 86400
+            if too_many or too_old:
+                path.unlink()
+
+    def restore(self, backup):
+        """Replace the records file with a backup, backing it up first."""
+        records = FileManager(backup, self.lenient, journal=False).load()
+        with self.lock():
+            self.save(records)
+
+    def export_zip(self) -> bytes:
+        """Zip the records, archives and journal under portable names."""
+        suffix = Path(self.filename).suffix
+        files = {f"{BACKUP_NAME}{suffix}": self.filename}
+        for manager in self.archive_managers():
+            year = Path(manager.filename).stem[-4:]
+            files[f"{BACKUP_NAME}-{year}{suffix}"] = manager.filename
+        if self.journal is not None and self.journal.exists():
+            files[f"{BACKUP_NAME}.journal.jsonl"] = self.journal.path
+        out = io.BytesIO()
+        with zipfile.ZipFile(out, "w", zipfile.ZIP_DEFLATED) as archive:
+            for name, path in files.items():
+                if Path(path).exists():
+                    archive.write(path, name)
+        return out.getvalue()
+
+    def restore_zip(self, data: bytes) -> dict[str, int]:
+        """Restore the records and archives of `export_zip`.
+
+        The journal in the zip is not restored, the restore itself is
+        journaled so it can be undone. Return the records of each file.
+        """
+        pattern = re.compile(rf"{BACKUP_NAME}(?:-(\d{{4}}))?\.[^.]+")
+        try:
+            archive = zipfile.ZipFile(io.BytesIO(data))
+        except zipfile.BadZipFile as e:
+            raise InvalidArgsError(f"Invalid backup: {e}")
+        restored = {}
+        with archive, tempfile.TemporaryDirectory() as tmp:
+            for name in archive.namelist():
+                match = pattern.fullmatch(name)
+                if match is None:
+                    continue
+                year = match.group(1)
+                target = self if year is None else FileManager(
+                    self.archive_path(year),
+                    self.lenient,
+                    self.use_lock,
+                    self.backup_config,
+                    self.journal is not None,
+                )
+                path = archive.extract(name, tmp)
+                target.restore(path)
+                restored[target.filename] = len(target.load())
+        if not restored:
+            raise InvalidArgsError("The backup has no records file.")
+        return restored
+
+    def undo(self):
+        """Revert the last change logged in the journal.
+
+        Return the number of records added and removed back.
+        """
+        if self.journal is None:
+            raise TaktError("The journal is disabled, nothing to undo.")
+        with self.lock():
+            events = self.journal.last_transaction()
+            if not events:
+                raise TaktError("Nothing to undo.")
+            inverse = [
+                {**e, "op": "remove" if e["op"] == "add" else "add"}
+                for e in events
+            ]
+            keys = Counter(self.journal.key(r) for r in self.load())
+            records = self.journal.records(self.journal.apply(keys, inverse))
+            if self.backup_config.get("enabled", True):
+                self.backup()
+            added = [self.journal.key(e["record"]) for e in inverse if e["op"] == "add"]
+            removed = [self.journal.key(e["record"]) for e in inverse if e["op"] == "remove"]
+            self.journal.append(added, removed, undo=events[0]["tx"])
+            self.save_snapshot(records)
+        return len(added), len(removed)
+
+    def rebuild(self):
+        """Rewrite the records file from the journal."""
+        if self.journal is None or not self.journal.exists():
+            raise TaktError(f"There is no journal for {self.filename}.")
+        with self.lock():
+            if self.backup_config.get("enabled", True):
+                self.backup()
+            records = self.journal.replay()
+            self.save_snapshot(records)
+        return len(records)
+
+    def lock(self):
+        if not self.use_lock:
+            return nullcontext()
+        return FileLock(self.filename)
+
+    def insert(self, **kwargs):
+        with self.lock():
+            records = self.load()
+            # newest first, leave can be recorded ahead of time
+            index = next(
+                (i for i, r in enumerate(records)
+                 if r[TIMESTAMP] <= kwargs[TIMESTAMP]),
+                len(records),
+            )
+            records.insert(index, kwargs)
+            self.save(records)
+
+    def remove(self, removed):
+        """Remove the records `removed`, found again under the lock.
+
+        They are matched by timestamp, kind and notes, if one is gone
+        because of another write meanwhile nothing is removed.
+        """
+        wanted = Counter(record_identity(r) for r in removed)
+        with self.lock():
+            kept = []
+            for record in self.load():
+                identity = record_identity(record)
+                if wanted[identity]:
+                    wanted[identity] -= 1
+                else:
+                    kept.append(record)
+            if +wanted:
+                raise TaktError(
+                    "The records were changed meanwhile, nothing removed.",
+                    hint="Run the command again.",
+                )
+            self.save(kept)
+
+    def update(self, expected, record):
+        """Change the record `expected` with the fields of `record`.
+
+        `expected` is found again under the lock by timestamp, kind and
+        notes, if another write changed it meanwhile nothing is written.
+        """
+        with self.lock():
+            records = self.load()
+            index = next(
+                (i for i, r in enumerate(records)
+                 if record_identity(r) == record_identity(expected)),
+                None,
+            )
+            if index is None:
+                raise TaktError(
+                    "The record was changed meanwhile, nothing written.",
+                    hint="Run the command again.",
+                )
+            records[index] = {**records[index], **record}
+            records.sort(key=lambda r: r[TIMESTAMP], reverse=True)
+            self.save(records)
+
+    def sort(self):
+        """Rewrite the file newest first, return whether it changed."""
+        self.warned_unsorted = True
+        with self.lock():
+            records = self.load()
+            if not self.unsorted:
+                return False
+            self.save(records)
+            return True
+
+    def merge(self, records):
+        """Add the records not present yet, return how many were added."""
+        with self.lock():
+            current = self.load()
+            known = {(r[TIMESTAMP], r[KIND]) for r in current}
+            new = [r for r in records if (r[TIMESTAMP], r[KIND]) not in known]
+            if not new:
+                return 0
+            merged = sorted(
+                current + new, key=lambda r: r[TIMESTAMP], reverse=True
+            )
+            self.save(merged)
+            return len(new)
+
+    def import_edits(self, records) -> tuple[int, int]:
+        """Merge `records` saved back by a spreadsheet, return added and edited.
+
+        Excel drops the seconds, so a record is the one present with the same
+        kind in the same minute, and its notes are updated if they differ.
+        """
+        with self.lock():
+            current = self.load()
+            present = {
+                (r[TIMESTAMP].floor("min"), r[KIND]): r for r in current
+            }
+            new = []
+            edited = 0
+            for record in records:
+                match = present.get((record[TIMESTAMP].floor("min"), record[KIND]))
+                if match is None:
+                    new.append(record)
+                elif match[NOTES] != record[NOTES]:
+                    match[NOTES] = record[NOTES]
+                    edited += 1
+            if new or edited:
+                self.save(sorted(
+                    current + new, key=lambda r: r[TIMESTAMP], reverse=True
+                ))
+            return len(new), edited
+
+    def apply_remote(self, added, removed) -> tuple[int, int]:
+        """Add the `added` records not present and remove the `removed` ones.
+
+        Records are matched by `record_identity`, return how many were added
+        and removed.
+        """
+        with self.lock():
+            records = self.load()
+            gone = Counter(record_identity(r) for r in removed)
+            kept = []
+            for record in records:
+                identity = record_identity(record)
+                if gone[identity]:
+                    gone[identity] -= 1
+                else:
+                    kept.append(record)
+            present = {record_identity(r) for r in kept}
+            new = [r for r in added if record_identity(r) not in present]
+            if new or len(kept) != len(records):
+                self.save(sorted(
+                    kept + new, key=lambda r: r[TIMESTAMP], reverse=True
+                ))
+            return len(new), len(records) - len(kept)
+
+    def first(self):
+        records = self.head(1)
+        return records[0] if records else None
+
+    def records_of_week(self, year, week, week_start=DEFAULT_WEEK_START):
+        df = self.read()
+        df[TIMESTAMP] = pd.to_datetime(df[TIMESTAMP])
+        df['week'] = df[TIMESTAMP].dt.strftime(WeekRef(week_start).format)
+        df['year'] = df[TIMESTAMP].dt.year
+        return df[(df['week'] == str(week)) & (df['year'] == year)]
+
+
+# Public names for scripts: a record is one row of the file, the store is
+# the file itself.
+Record = FileRow
+Store = FileManager
+
+
+class GitSync:
+    """Keep the records file in sync with the git repository holding it."""
+
+    def __init__(self, filename, prompt=True):
+        self.filename = os.path.abspath(filename)
+        # without a terminal to ask on git fails instead of prompting
+        self.prompt = prompt
+        self.root = self.find_root()
+
+    def find_root(self):
+        cwd = os.path.dirname(self.filename)
+        out = subprocess.run(
+            ["git", "rev-parse", "--show-toplevel"],
+            cwd=cwd, capture_output=True, text=True,
+        )
+        if out.returncode != 0:
+            raise GitError(f"{self.filename} is not inside a git repository.")
+        return out.stdout.strip()
+
+    @traced("git")
+    def git(self, *args):
+        env = None if self.prompt else {**os.environ, "GIT_TERMINAL_PROMPT": "0"}
+        out = subprocess.run(
+            ["git", *args], cwd=self.root, capture_output=True, text=True,
+            env=env,
+        )
+        if out.returncode != 0:
+            cmd = " ".join(["git", *args])
+            raise GitError(f"`{cmd}` failed:\n{out.stderr or out.stdout}")
+        return out.stdout
+
+    def has_remote(self):
+        return bool(self.git("remote").strip())
+
+    def pull(self, resolver=None):
+        """Pull with rebase.
+
+        On a conflict in the records file `resolver(base, remote, local)`,
+        given the records of each side, returns the merged records. Without
+        resolver, or if other files conflict, the rebase is aborted.
+        """
+        if not self.has_remote():
+            return
+        try:
+            self.git("pull", "--rebase")
+        except GitError as e:
+            error = e
+        else:
+            return
+        rebase_dir = Path(self.root, ".git", "rebase-merge")
+        while rebase_dir.exists() or "CONFLICT" in str(error):
+            if resolver is None or self.conflicted() != [self.relpath()]:
+                self.git("rebase", "--abort")
+                raise GitError(
+                    "Conflict while pulling records, nothing was written. "
+                    f"Resolve it manually in {self.root} and retry."
+                ) from error
+            # while rebasing, stage 2 is upstream and 3 the local commit
+            base, remote, local = (self.conflict_side(stage) for stage in (1, 2, 3))
+            try:
+                records = resolver(base, remote, local)
+            except BaseException:
+                self.git("rebase", "--abort")
+                raise
+            FileManager(self.filename, lock=False, journal=False).save_snapshot(records)
+            self.git("add", self.filename)
+            try:
+                self.git("-c", "core.editor=true", "rebase", "--continue")
+                return
+            except GitError as e:
+                error = e
+        raise error
+
+    def relpath(self):
+        return Path(os.path.relpath(self.filename, self.root)).as_posix()
+
+    def conflicted(self):
+        """Paths with merge conflicts."""
+        out = self.git("diff", "--name-only", "--diff-filter=U")
+        return out.split()
+
+    def conflict_side(self, stage) -> list[dict]:
+        """Records of one side of a conflict, empty if it has no file."""
+        try:
+            text = self.git("show", f":{stage}:{self.relpath()}")
+        except GitError:
+            return []
+        with tempfile.TemporaryDirectory() as tmp:
+            path = os.path.join(tmp, Path(self.filename).name)
+            with open(path, "w", encoding="utf-8") as f:
+                f.write(text)
+            return FileManager(path, lock=False, journal=False).load()
+
+    def commit(self, message, paths=None):
+        paths = [os.path.abspath(p) for p in paths or [self.filename]]
+        self.git("add", *paths)
+        status = self.git("status", "--porcelain", "--", *paths)
+        if not status.strip():
+            return
+        self.git("commit", "-m", message, "--", *paths)
+
+    def push(self, tags=()):
+        if not self.has_remote():
+            return
+        self.git("push")
+        remote = self.git("remote").split()[0]
+        for tag in tags:
+            self.git("push", remote, f"refs/tags/{tag}")
+
+    def tag_week(self, now):
+        """Tag the last commit before the week of `now` with its ISO week.
+
+        It snapshots the records as they were at the end of that week, return
+        the new tag or None if there is nothing to tag.
+        """
+        week_start = (now - pd.Timedelta(days=now.weekday())).normalize()
+        out = self.git(
+            "log", "-1", "--format=%H %cI", f"--before={week_start.isoformat()}"
+        )
+        if not out.strip():
+            return None
+        sha, date = out.split()
+        year, week, _ = pd.Timestamp(date).isocalendar()
+        tag = WEEK_TAG.format(year=year, week=week)
+        if self.git("tag", "--list", tag).strip():
+            return None
+        self.git("tag", tag, sha)
+        return tag
+
+    def add_attribute(self, attribute):
+        """Set a gitattribute for the records file."""
+        relpath = os.path.relpath(self.filename, self.root)
+        line = f"{relpath} {attribute}\n"
+        path = Path(self.root, ".gitattributes")
+        content = path.read_text() if path.exists() else ""
+        if line in content.splitlines(keepends=True):
+            return
+        if content and not content.endswith("\n"):
+            content += "\n"
+        path.write_text(content + line)
+
+    def encrypt_with_git_crypt(self):
+        try:
+            out = subprocess.run(
+                ["git-crypt", "init"], cwd=self.root,
+                capture_output=True, text=True,
+            )
+        except FileNotFoundError:
+            raise GitError("`git-crypt` not found, check if it is installed.")
+        # git-crypt refuses to init twice, which is fine for us
+        if out.returncode != 0 and "already" not in out.stderr:
+            raise GitError(f"`git-crypt init` failed:\n{out.stderr}")
+        self.add_attribute("filter=git-crypt diff=git-crypt")
+
+    def encrypt_with_age(self, recipient, identity):
+        recipient = shlex.quote(recipient)
+        decrypt = f"age -d -i {shlex.quote(os.path.expanduser(identity))}"
+        # age output changes on every run, reuse the committed ciphertext
+        # while the plaintext is the same so `git status` stays clean
+        clean = (
+            'tmp=$(mktemp) && cat >"$tmp" && '
+            f'if git cat-file blob "HEAD:$1" 2>/dev/null | {decrypt} 2>/dev/null'
+            ' | cmp -s - "$tmp"; then git cat-file blob "HEAD:$1"; '
+            f'else age -a -r {recipient} <"$tmp"; fi; '
+            'status=$?; rm -f "$tmp"; exit $status'
+        )
+        self.git(
+            "config", "filter.takt-age.clean",
+            f"sh -c {shlex.quote(clean)} -- %f",
+        )
+        self.git("config", "filter.takt-age.smudge", decrypt)
+        self.git("config", "filter.takt-age.required", "true")
+        self.git("config", "diff.takt-age.textconv", decrypt)
+        self.add_attribute("filter=takt-age diff=takt-age")
+
+
+def merge_records(base, remote, local):
+    """Three-way merge of records keyed by timestamp and kind.
+
+    Return the merged records, newest first, and the conflicts as
+    (remote, local) pairs where either side may be None if it deleted the
+    record the other one changed.
+    """
+    def key(record):
+        return (record[TIMESTAMP], record[KIND])
+
+    def content(record):
+        if record is None:
+            return None
+        return (record[NOTES] or "", record.get(META) or "")
+
+    base, remote, local = (
+        {key(r): r for r in records} for records in (base, remote, local)
+    )
+    merged, conflicts = [], []
+    for k in sorted(set(remote) | set(local), reverse=True):
+        old, theirs, ours = base.get(k), remote.get(k), local.get(k)
+        if content(theirs) == content(ours) or content(ours) == content(old):
+            chosen = theirs
+        elif content(theirs) == content(old):
+            chosen = ours
+        else:
+            conflicts.append((theirs, ours))
+            continue
+        if chosen is not None:
+            merged.append(chosen)
+    return merged, conflicts
+
+
+class DailyRef:
+    @staticmethod
+    def group(timestamp):
+        group_by = timestamp.date().isoformat()
+        return group_by
+
+
+class WeekRef:
+    def __init__(self, week_start: str = DEFAULT_WEEK_START):
+        if week_start not in WEEK_STARTS:
+            raise InvalidArgsError(
+                f"Week start {week_start} not supported, use sunday or monday."
+            )
+        self.format = WEEK_STARTS[week_start]
+
+    def group(self, timestamp):
+        year = timestamp.year
+        week = int(timestamp.strftime(self.format))
+        group_by = f"{year}-W{week:02d}"
+        return group_by
+
+
+class YearRef:
+    @staticmethod
+    def group(timestamp):
+        year = timestamp.year
+        group_by = f"{year}"
+        return group_by
+
+
+class MonthRef:
+    @staticmethod
+    def group(timestamp):
+        year = timestamp.year
+        month = timestamp.month
+        group_by = f"{year}-M{month:02d}"
+        return group_by
+
+
+class QuarterRef:
+    @staticmethod
+    def group(timestamp):
+        year = timestamp.year
+        quarter = (timestamp.month - 1) // 3 + 1
+        group_by = f"{year}-Q{quarter}"
+        return group_by
+
+
+class HalfRef:
+    @staticmethod
+    def group(timestamp):
+        year = timestamp.year
+        half = (timestamp.month - 1) // 6 + 1
+        group_by = f"{year}-H{half}"
+        return group_by
+
+
+class Session(dict):
+    """A check in paired with its check out, breaks already subtracted.
+
+    `inferred` sessions were not checked out: they end now or were closed
+    by the auto check out. `coworkers` paired in the session, see `check
+    --with`.
+    """
+
+    def __init__(
+        self, start, end, notes, breaks=pd.Timedelta(0), inferred=False,
+        coworkers=(),
+    ):
+        super().__init__(
+            start=start,
+            end=end,
+            duration=end - start - breaks,
+            breaks=breaks,
+            notes=notes,
+            inferred=inferred,
+            coworkers=list(coworkers),
+        )
+
+
+def pair_sessions(records, now) -> list[Session]:
+    """Pair the check ins and outs of `records` into sessions, oldest first.
+
+    A check in while checked in and a check out while checked out are
+    ignored, a break without resume lasts until the check out and the open
+    session, if any, ends at `now`.
+    """
+    records = sorted(records, key=lambda r: r[TIMESTAMP], reverse=True)
+    sessions = []
+    check_in, break_start, breaks = None, None, pd.Timedelta(0)
+    for record in reversed(records):
+        kind, timestamp = record[KIND], record[TIMESTAMP]
+        if kind == 'in' and check_in is None:
+            check_in, break_start, breaks = record, None, pd.Timedelta(0)
+        elif check_in is None:
+            continue
+        elif kind == BREAK_START and break_start is None:
+            break_start = timestamp
+        elif kind == BREAK_END and break_start is not None:
+            breaks += timestamp - break_start
+            break_start = None
+        elif kind == 'out':
+            if break_start is not None:
+                breaks += timestamp - break_start
+            inferred = "inferred" in parse_meta(record.get(META))
+            sessions.append(Session(
+                check_in[TIMESTAMP], timestamp, check_in[NOTES], breaks, inferred,
+                coworkers_of(check_in.get(META)),
+            ))
+            check_in = None
+    if check_in is not None:
+        if break_start is not None:
+            breaks += now - break_start
+        sessions.append(Session(
+            check_in[TIMESTAMP], now, check_in[NOTES], breaks, True,
+            coworkers_of(check_in.get(META)),
+        ))
+    return sessions
+
+
+def find_overlaps(records) -> list[dict]:
+    """Sessions checked in before the one open was checked out, oldest first.
+
+    Merging two files or editing by hand can interleave sessions, e.g. in at
+    09:00, in at 10:00, out at 12:00, out at 13:00. Each overlap has the
+    `first` check in, the `ins` made while checked in, the `outs` that left
+    another check out to follow and the `last` check out. A check in while
+    checked in followed by a single check out is just a repeated check in.
+    """
+    checks = [
+        r for r in sorted(records, key=lambda r: r[TIMESTAMP])
+        if r[KIND] in ('in', 'out')
+    ]
+    overlaps = []
+    first, ins, outs = None, [], []
+    for i, record in enumerate(checks):
+        if record[KIND] == 'in':
+            if first is None:
+                first = record
+            else:
+                ins.append(record)
+            continue
+        if first is None:
+            continue
+        following = checks[i + 1] if i + 1 < len(checks) else None
+        if ins and following is not None and following[KIND] == 'out':
+            outs.append(record)
+            continue
+        if outs:
+            overlaps.append(
+                {"first": first, "ins": ins, "outs": outs, "last": record}
+            )
+        first, ins, outs = None, [], []
+    return overlaps
+
+
+def repair_overlaps(records, overlaps, mode) -> list[dict]:
+    """Records, newest first, with `overlaps` repaired.
+
+    `merge` makes one session of each overlap, from its first check in to
+    its last check out. `truncate` checks out of each session when the next
+    one checks in, keeping their notes apart. Both count the time once.
+    """
+    ins = {id(r) for o in overlaps for r in o["ins"]}
+    drop = {id(r) for o in overlaps for r in o["outs"]}
+    if mode == "merge":
+        drop |= ins
+    repaired = []
+    for record in records:
+        if id(record) in drop:
+            continue
+        repaired.append(record)
+        if mode == "truncate" and id(record) in ins:
+            # newest first, so the check out sorts before the check in
+            repaired.append({**record, KIND: 'out', NOTES: '', META: ''})
+    return repaired
+
+
+def split_session(session, day_start=pd.Timedelta(0)) -> list[Session]:
+    """Cut `session` where each day it crosses starts, e.g. at midnight.
+
+    Breaks are shared in proportion to the length of the pieces, the records
+    don't say on which side of the cut they were. Week and month boundaries
+    fall on day boundaries, so the pieces never cross them either.
+    """
+    start, end = session['start'], session['end']
+    span = end - start
+    pieces = []
+    while True:
+        next_day = (start - day_start).normalize() + pd.DateOffset(days=1)
+        cut = min(next_day + day_start, end)
+        breaks = session['breaks'] * ((cut - start) / span) if span else span
+        pieces.append(Session(
+            start, cut, session['notes'], breaks, session['inferred'],
+            session['coworkers'],
+        ))
+        if cut >= end:
+            return pieces
+        start = cut
+
+
+class Aggregator:
+    def __init__(
+        self,
+        period: str = 'daily',
+        verbose=True,
+        week_start: str = DEFAULT_WEEK_START,
+        day_start: pd.Timedelta = pd.Timedelta(0),
+        min_duration: pd.Timedelta = None,
+        split_days: bool = True,
+        suspicious: pd.Timedelta = None,
+    ):
+        self.period = period
+        self.verbose = verbose
+        # shorter sessions are left out of the reports, not deleted
+        self.min_duration = min_duration
+        # days start at `day_start`, sessions crossing it are split between
+        # both days or, without `split_days`, count for the day they started
+        self.day_start = day_start
+        self.split_days = split_days
+        # longer sessions are flagged, likely a forgotten check out
+        self.suspicious = suspicious
+        self.sessions = None
+        self.whole_sessions = None
+        self.adjustments = []
+        self.raw_total = pd.Timedelta(0)
+        self.inferred_now = False
+        self.flagged = 0
+        if period == 'wtd':
+            self.time_agg = WeekRef(week_start).group
+        elif period == 'ytd':
+            self.time_agg = YearRef.group
+        elif period == 'mtd':
+            self.time_agg = MonthRef.group
+        elif period == 'quarter':
+            self.time_agg = QuarterRef.group
+        elif period == 'half':
+            self.time_agg = HalfRef.group
+        elif period == 'daily':
+            self.time_agg = DailyRef.group
+        else:
+            raise InvalidArgsError(
+                f"Period {period} not supported, "
+                f"use {', '.join(SUMMARY_PERIODS)}."
+            )
+
+    @traced("aggregate")
+    def calculate(self, records: list[dict]) -> list[dict]:
+        trace.count("aggregate", len(records))
+        now = clock.now()
+        sessions = pair_sessions(records, now)
+        self.inferred_now = bool(sessions) and sessions[-1]['end'] == now
+        # rules changing the numbers shown, disclosed under the reports
+        self.adjustments = []
+        self.raw_total = sum((s['duration'] for s in sessions), pd.Timedelta(0))
+        if self.min_duration is not None:
+            short = [s for s in sessions if s['duration'] < self.min_duration]
+            if short:
+                hidden = sum((s['duration'] for s in short), pd.Timedelta(0))
+                self.adjustments.append(
+                    f"{len(short)} sessions shorter than "
+                    f"{format_short(int(self.min_duration.total_seconds()))} "
+                    f"hidden, {format_time(hidden)} in total"
+                )
+            sessions = [s for s in sessions if s['duration'] >= self.min_duration]
+        self.whole_sessions = sessions
+        flagged = {
+            id(s) for s in sessions
+            if self.suspicious is not None and s['duration'] > self.suspicious
+        }
+        self.flagged = len(flagged)
+        if self.verbose:
+            self.warn()
+        sessions = [(s, id(s) in flagged) for s in sessions]
+        if self.split_days:
+            split, moved = 0, pd.Timedelta(0)
+            pieces = []
+            for session, suspicious in sessions:
+                parts = split_session(session, self.day_start)
+                group = self.time_agg(parts[0]['start'] - self.day_start)
+                shifted = [
+                    p['duration'] for p in parts
+                    if self.time_agg(p['start'] - self.day_start) != group
+                ]
+                if shifted:
+                    split += 1
+                    moved += sum(shifted, pd.Timedelta(0))
+                pieces += [(p, suspicious) for p in parts]
+            sessions = pieces
+            if split:
+                self.adjustments.append(
+                    f"{split} sessions split at the day start, "
+                    f"{format_time(moved)} counted in the next period"
+                )
+        row_collection = []
+        for session, suspicious in sessions:
+            day = session['start'] - self.day_start
+            row_collection.append([
+                self.time_agg(day),
+                session['duration'],
+                [day.date()],
+                [session['notes']],
+                session['start'],
+                session['end'],
+                session['inferred'],
+                suspicious,
+            ])
+
+        # aggregate to pandas
+        table = pd.DataFrame(
+            row_collection,
+            columns=[
+                'group', 'duration', 'dates', 'notes', 'start', 'end',
+                'inferred', 'suspicious',
+            ],
+        )
+        self.sessions = table
+        columns = ['group', 'duration', 'dates', 'notes', 'suspicious']
+        summ = table[columns].groupby('group').sum()
+        summ.sort_index(inplace=True, ascending=False)
+        summ.loc[:, "dates"] = summ.dates.apply(set)
+        summ.loc[:, "notes"] = summ.notes.apply(set)
+        summ.loc[:, "avg.duration"] = summ.duration / summ.dates.apply(len)
+        summ = summ.reset_index()
+        row_collection = summ.to_dict(orient='records')
+        return row_collection
+
+    def warn(self):
+        """Print the notes about the last `calculate`."""
+        if self.inferred_now:
+            console.print("NOTE: Last out was inferred using `Timestamp.now()`.")
+        if self.flagged:
+            console.print(
+                f"[yellow]WARNING:[/] {self.flagged} sessions longer than "
+                f"{format_short(int(self.suspicious.total_seconds()))}, "
+                "marked with " + SUSPICIOUS_MARK + ", run `takt review`."
+            )
+
+    def session_list(self) -> list[dict]:
+        """Return every session, oldest first, not split between days."""
+        columns = ['start', 'end', 'duration', 'notes', 'inferred', 'coworkers']
+        return [{c: s[c] for c in columns} for s in self.whole_sessions]
+
+    def sessions_by_group(self) -> dict[str, list[dict]]:
+        """Return the sessions of each group, oldest first.
+
+        A session split between days is listed in each of them.
+        """
+        sessions = self.sessions.sort_values('start')
+        columns = ['start', 'end', 'duration', 'notes', 'suspicious']
+        return {
+            group: rows[columns].to_dict('records')
+            for group, rows in sessions.groupby('group')
+        }
+
+    def top_notes(self, n: int) -> dict[str, list[tuple]]:
+        """Return the `n` notes with most time by group."""
+        sessions = self.sessions.assign(note=self.sessions.notes.str[0])
+        sessions = sessions[sessions.note != '']
+        totals = sessions.groupby(['group', 'note']).duration.sum()
+        out = {}
+        for group, notes in totals.groupby(level='group'):
+            notes = notes.droplevel('group').sort_values(ascending=False)
+            out[group] = list(notes.head(n).items())
+        return out
+
+
+def weekly_averages(sessions: list[dict], week_start: str, weeks: int) -> list[dict]:
+    """Hours of each week and their rolling average over `weeks` weeks."""
+    if not sessions:
+        return []
+    hours = pd.Series(
+        [to_hours(s['duration']) for s in sessions],
+        index=pd.DatetimeIndex([s['start'] for s in sessions]),
+    ).sort_index()
+    # weeks without sessions count as zero hours in the average
+    totals = hours.resample(WEEK_FREQS[week_start]).sum()
+    averages = totals.rolling(weeks, min_periods=1).mean()
+    ref = WeekRef(week_start)
+    return [
+        {
+            "week": ref.group(end - pd.Timedelta(days=6)),
+            "hours": total,
+            "average": average,
+        }
+        for end, total, average in zip(totals.index, totals, averages)
+    ]
+
+
+def rest_periods(sessions: list[dict], day_start=pd.Timedelta(0)) -> list[dict]:
+    """Rest between the last check out of a day and the next check in."""
+    days = {}
+    for s in sorted(sessions, key=lambda s: s['start']):
+        date = (s['start'] - day_start).date()
+        day = days.setdefault(date, [s['start'], s['end']])
+        day[1] = max(day[1], s['end'])
+    bounds = [days[day] for day in sorted(days)]
+    return [
+        {"out": prev[1], "in": next_[0], "rest": next_[0] - prev[1]}
+        for prev, next_ in zip(bounds, bounds[1:])
+    ]
+
+
+def session_stats(sessions: list[dict], day_start=pd.Timedelta(0)) -> dict:
+    """Averages, streak, breaks and busiest weekday of `sessions`.
+
+    Start and end times are measured from the midnight of the day the
+    session counts for, so an end past midnight averages as e.g. 25:30.
+    """
+    days = {}
+    for s in sorted(sessions, key=lambda s: s['start']):
+        date = (s['start'] - day_start).date()
+        day = days.setdefault(date, [s['start'], s['end'], pd.Timedelta(0)])
+        day[1] = max(day[1], s['end'])
+        day[2] += s['duration']
+    midnight = {date: pd.Timestamp(date) for date in days}
+    streak, best = [], []
+    for date in sorted(days):
+        if streak and (date - streak[-1]).days != 1:
+            streak = []
+        streak.append(date)
+        if len(streak) > len(best):
+            best = list(streak)
+    weekdays = {}
+    for date, (_, _, duration) in days.items():
+        name = f"{date:%A}"
+        weekdays[name] = weekdays.get(name, pd.Timedelta(0)) + duration
+    busiest = max(weekdays, key=weekdays.get)
+    zero = pd.Timedelta(0)
+    total = sum((s['duration'] for s in sessions), zero)
+    return {
+        "sessions": len(sessions),
+        "days": len(days),
+        "total": total,
+        "average_start": sum(
+            (start - midnight[d] for d, (start, _, _) in days.items()), zero
+        ) / len(days),
+        "average_end": sum(
+            (end - midnight[d] for d, (_, end, _) in days.items()), zero
+        ) / len(days),
+        "average_session": total / len(sessions),
+        "average_day": total / len(days),
+        "longest_streak": len(best),
+        "streak_from": best[0],
+        "streak_to": best[-1],
+        "breaks": sum(
+            (s['end'] - s['start'] - s['duration'] for s in sessions), zero
+        ),
+        "busiest_weekday": busiest,
+        "busiest_weekday_total": weekdays[busiest],
+    }
+
+
+def to_hours(duration: pd.Timedelta) -> float:
+    return duration.total_seconds() * SECONDS_TO_HOURS
+
+
+def format_time_explicit(duration: pd.Timedelta, hours_by_day=7.5) -> str:
+    hours = to_hours(duration)
+    d = int(hours / hours_by_day)
+    h = int((hours % hours_by_day) // 1)
+    m = int((hours % hours_by_day % 1) * 60)
+    if d > 0:
+        return f"{d:>02.0f} days{h:> 2.0f}H {m:> 3.0f} m"
+    return f"{h:> 10.0f} H{m:> 3.0f} m"
+
+
+def format_signed(duration: pd.Timedelta) -> str:
+    sign = "-" if duration < pd.Timedelta(0) else "+"
+    return sign + format_time(abs(duration))
+
+
+def format_time(duration: pd.Timedelta) -> str:
+    minutes = int(duration.total_seconds() // 60)
+    h = str(minutes // 60).zfill(2)
+    m = str(minutes % 60).zfill(2)
+    return f"{h}:{m}"
+
+
+def plural(n: int, unit: str) -> str:
+    return f"{n} {unit}" if n == 1 else f"{n} {unit}s"
+
+
+def humanize_duration(duration: pd.Timedelta, about=False) -> str:
+    """Spell out a duration, `3 hours 12 minutes`.
+
+    With `about` it is rounded to half hours, `about 38 and a half hours`.
+    """
+    minutes = int(duration.total_seconds() // 60)
+    if not about:
+        h, m = divmod(minutes, 60)
+        if not h:
+            return plural(m, "minute")
+        return plural(h, "hour") + (f" {plural(m, 'minute')}" if m else "")
+    h, half = divmod(round(minutes / 30), 2)
+    if not h:
+        return "about half an hour" if half else "a few minutes"
+    if h == 1:
+        return "about an hour and a half" if half else "about an hour"
+    if not half:
+        return f"about {h} hours"
+    return f"about {h} and a half hours"
+
+
+class TableSummary:
+    # WIP
+    def __init__(self):
+        self.table = None
+
+    def build_table(self, records):
+        table = Table(show_header=True, header_style="bold magenta")
+        columns = [c for c in records[0].keys()]
+        for column in columns:
+            table.add_column(column, style="dim")
+
+        for row in records:
+            table.add_row(*row.values())
+        self.table = table
+        return table
+
+    def show(self):
+        console.print(self.table)
+
+
+def format_notes(notes) -> str:
+    """Deduplicated notes, grouped by project when they have one."""
+    by_project = {}
+    for note in sorted(n for n in notes if n):
+        by_project.setdefault(project_of(note), []).append(note)
+    lines = []
+    for project, project_notes in sorted(
+        by_project.items(), key=lambda item: item[0] or ""
+    ):
+        if project is None:
+            lines.extend(project_notes)
+        else:
+            lines.append(f"+{project}: " + "; ".join(project_notes))
+    return "\n".join(lines)
+
+
+def fsync_dir(directory):
+    try:
+        fd = os.open(directory, os.O_RDONLY)
+    except OSError:  # e.g. windows
+        return
+    try:
+        os.fsync(fd)
+    finally:
+        os.close(fd)
+
+
+def verify_written(path, digest, filename):
+    """Fail unless `path` reads back with the sha256 `digest`."""
+    with open(path, 'rb') as f:
+        if hashlib.sha256(f.read()).hexdigest() != digest:
+            raise TaktError(
+                f"{filename} not written, the new copy read back differs.",
+                hint="The file is untouched, check the disk and try again.",
+            )
+
+
+def write_atomic(filename, text, verify=None):
+    """Write `text` to a temp file next to `filename` and rename it.
+
+    The temp file lives in the same directory so the rename is atomic and
+    never crosses filesystems. It is read back and compared before replacing
+    the target, `verify(path)` can check its content further, raising to keep
+    the original.
+    """
+    filename = os.path.abspath(filename)
+    directory = os.path.dirname(filename)
+    data = text if isinstance(text, bytes) else text.encode('utf-8')
+    digest = hashlib.sha256(data).hexdigest()
+    fd, tmp = tempfile.mkstemp(dir=directory, prefix=".takt-", suffix=".tmp")
+    try:
+        if os.path.exists(filename):
+            # mkstemp creates it private, keep the mode of the original
+            shutil.copymode(filename, tmp)
+        with os.fdopen(fd, 'wb') as f:
+            f.write(data)
+            f.flush()
+            os.fsync(f.fileno())
+        verify_written(tmp, digest, filename)
+        if verify is not None:
+            verify(tmp)
+        os.replace(tmp, filename)
+        fsync_dir(directory)
+    except BaseException:
+        if os.path.exists(tmp):
+            os.remove(tmp)
+        raise
+
+
+def render_output(filename, renderable, rows: list[dict]) -> str:
+    """Render for `filename`, the format is inferred from its extension."""
+    ext = Path(filename).suffix.lower()
+    if ext == ".json":
+        return json.dumps(rows, indent=2, default=str) + "\n"
+    if ext == ".csv":
+        return pd.DataFrame(rows).to_csv(index=False)
+    recorder = Console(record=True, file=io.StringIO(), width=console.width)
+    recorder.print(renderable)
+    if ext in (".html", ".htm"):
+        return recorder.export_html()
+    if ext in (".txt", ".md", ".log", ""):
+        return recorder.export_text()
+    raise InvalidArgsError(
+        f"Can't infer the output format of {filename}, "
+        "use .txt, .csv, .json or .html."
+    )
+
+
+def excel_csv(rows: list[dict], decimal=EXCEL_DECIMAL) -> bytes:
+    """CSV the way Excel opens it in Europe: `;`, decimal commas and a BOM."""
+    def cell(value):
+        if isinstance(value, float):
+            return f"{value:.2f}".replace(".", decimal)
+        if isinstance(value, (list, tuple)):
+            return ", ".join(str(v) for v in value)
+        return "" if value is None else str(value)
+
+    out = io.StringIO()
+    writer = csv.writer(out, delimiter=";", lineterminator="\r\n")
+    if rows:
+        writer.writerow(list(rows[0]))
+    for row in rows:
+        writer.writerow([cell(v) for v in row.values()])
+    return codecs.BOM_UTF8 + out.getvalue().encode("utf-8")
+
+
+def sign_file(filename, method, key) -> str:
+    """Write a detached signature of `filename`, return its path."""
+    key = os.path.expanduser(key)
+    if method == "minisign":
+        signature = f"{filename}.minisig"
+        cmd = ["minisign", "-S", "-s", key, "-m", filename, "-x", signature]
+    elif method == "ssh":
+        signature = f"{filename}.sig"
+        cmd = [
+            "ssh-keygen", "-Y", "sign", "-f", key, "-n", SSH_SIGN_NAMESPACE,
+            filename,
+        ]
+    else:
+        raise InvalidArgsError(
+            f"Signing with {method} not supported, use {', '.join(SIGN_METHODS)}."
+        )
+    try:
+        # both tools may ask for the key password on the terminal
+        out = subprocess.run(cmd, stdout=subprocess.PIPE, text=True)
+    except FileNotFoundError:
+        raise TaktError(f"`{cmd[0]}` not found, check if it is installed.")
+    if out.returncode != 0:
+        raise TaktError(f"Signing {filename} with {method} failed.")
+    return signature
+
+
+def pager_command():
+    """Pager for long output like git: TAKT_PAGER, `pager` or PAGER.
+
+    None when paging is off, with `--no-pager` or `pager = false`.
+    """
+    if not state["pager"]:
+        return None
+    command = os.getenv("TAKT_PAGER")
+    if command is None:
+        command = load_config().get("pager", os.getenv("PAGER", DEFAULT_PAGER))
+    return command or None
+
+
+def page(renderable):
+    """Print `renderable`, through the pager if it doesn't fit the terminal."""
+    command = pager_command() if console.is_terminal else None
+    if command is None:
+        console.print(renderable)
+        return
+    with console.capture() as capture:
+        console.print(renderable)
+    text = capture.get()
+    if text.count("\n") < console.height:
+        sys.stdout.write(text)
+        return
+    out = subprocess.run(command, shell=True, input=text, text=True)
+    # the shell exits with 127 when the pager is not installed
+    if out.returncode == 127:
+        sys.stdout.write(text)
+
+
+def plain_lines(rows: list[dict]) -> str:
+    """`Label: value` lines of every row, rows apart by a blank line."""
+    blocks = []
+    for row in rows:
+        lines = []
+        for key, value in row.items():
+            if isinstance(value, (list, tuple, set)):
+                value = ", ".join(str(v) for v in value)
+            label = str(key).replace("_", " ").capitalize()
+            lines.append(f"{label}: {'' if value is None else value}")
+        blocks.append("\n".join(lines))
+    return "\n\n".join(blocks)
+
+
+@traced("render")
+def emit(renderable, rows: list[dict], output=None):
+    """Print `renderable` or write it to `output`."""
+    if output is None and state["plain"]:
+        renderable = Text(plain_lines(rows))
+    if output is None:
+        page(renderable)
+        return
+    write_atomic(output, render_output(output, renderable, rows))
+
+
+@traced("render")
+def display_summary_table(
+    summary_dict: list[dict],
+    limit=SUMMARY_LIMIT,
+    top_notes=None,
+    sessions=None,
+    show_notes=False,
+    output=None,
+    report=None,
+    earnings=None,
+    adjustments=None,
+):
+    """Render a summary, `report` holds the `[report]` settings.
+
+    `earnings` maps each group to the money earned, see `Takt.earnings`.
+    `adjustments` are disclosed under the table, see `Takt.adjustments`.
+    """
+    report = report or {}
+    style = report.get("style", "table")
+    if style not in REPORT_STYLES:
+        raise InvalidArgsError(
+            f"Style {style} not supported, use {', '.join(REPORT_STYLES)}."
+        )
+    target = report.get("target")
+    target = parse_duration(target) if target else None
+    rules = list(adjustments["rules"]) if adjustments else []
+    if report.get("humanize"):
+        def fmt(duration):
+            return humanize_duration(duration, about=True)
+        rules.append("durations rounded to half hours")
+    else:
+        fmt = format_time
+    footnote = None
+    if rules:
+        footnote = "Adjusted: " + "; ".join(rules) + "."
+        if adjustments:
+            footnote += (
+                f" Raw total {format_time(adjustments['raw'])}, "
+                f"adjusted {format_time(adjustments['adjusted'])}, since "
+                f"{adjustments['since']:%Y-%m-%d}."
+            )
+    columns = ["Date", "Hours", "N.Days", "Avg Hours"]
+    if earnings is not None:
+        columns.append(f"Earnings ({report.get('currency', DEFAULT_CURRENCY)})")
+    if top_notes is not None:
+        columns.append("Top Notes")
+    show_notes = show_notes or sessions is not None
+    if show_notes:
+        columns.append("Notes")
+
+    # (cells, row style, style of the average) of every line
+    lines = []
+    for i, row in enumerate(summary_dict):
+        day = row['group']
+        total_time = row['duration']
+        dates = row['dates']
+        nobs = len(dates)
+
+        total_time_str = fmt(total_time)
+        avg_time = total_time / nobs if nobs else pd.Timedelta(0)
+        avg_time_str = fmt(avg_time)
+        mark = None
+        if target is not None:
+            mark = "green" if avg_time >= target else "red"
+
+        # days with sessions too long to be true
+        flagged = row.get('suspicious', 0)
+        cells = [
+            f"{day} {SUSPICIOUS_MARK}" if flagged else day,
+            total_time_str, str(nobs), avg_time_str,
+        ]
+        if earnings is not None:
+            cells.append(f"{earnings.get(day, 0.0):,.2f}")
+        if top_notes is not None:
+            cells.append("\n".join(
+                f"{note} ({fmt(duration)})"
+                for note, duration in top_notes.get(day, [])
+            ))
+        if show_notes:
+            cells.append(format_notes(row['notes']) if sessions is None else "")
+        lines.append((cells, "bold" if sessions is not None else None, mark))
+        for session in (sessions or {}).get(day, []):
+            flag = f" {SUSPICIOUS_MARK}" if session.get('suspicious') else ""
+            cells = [
+                f"  {session['start']:%H:%M}-{session['end']:%H:%M}{flag}",
+                fmt(session['duration']),
+                "",
+                "",
+            ]
+            if earnings is not None:
+                cells.append("")
+            if top_notes is not None:
+                cells.append("")
+            cells.append(session['notes'][0])
+            lines.append((cells, None, None))
+        if i >= limit:
+            break
+
+    if style == "tsv":
+        text = "".join(
+            "\t".join(c.replace("\n", " | ").replace("\t", " ") for c in cells)
+            + "\n"
+            for cells in [columns] + [cells for cells, _, _ in lines]
+        )
+        if output is None:
+            sys.stdout.write(text)
+        else:
+            write_atomic(output, text)
+        if footnote:
+            err_console.print(footnote, markup=False)
+        return
+
+    plain = style == "plain"
+    table = Table(
+        show_header=True,
+        header_style=None if plain else "bold magenta",
+        box=None if plain else box.HEAVY_HEAD,
+    )
+    notes_width = report.get("notes_width", NOTES_WIDTH)
+    wrap = report.get("wrap_notes", True)
+    for column in columns:
+        if column in ("Notes", "Top Notes"):
+            table.add_column(
+                column,
+                style=None if plain else "dim",
+                max_width=notes_width,
+                overflow="fold" if wrap else "ellipsis",
+                no_wrap=not wrap,
+            )
+        else:
+            table.add_column(column, style=None if plain else "dim")
+    for cells, row_style, mark in lines:
+        if mark is not None and not plain:
+            cells = [*cells[:3], f"[{mark}]{cells[3]}[/]", *cells[4:]]
+        table.add_row(*cells, style=None if plain else row_style)
+    captions = []
+    if any(row.get('suspicious', 0) for row in summary_dict[:limit + 1]):
+        captions.append(
+            f"{SUSPICIOUS_MARK} sessions too long to be true, see `takt review`."
+        )
+    if footnote:
+        captions.append(footnote)
+    if captions:
+        table.caption = "\n".join(captions)
+
+    rows = [summary_to_json(row) for row in summary_dict[:limit + 1]]
+    if earnings is not None:
+        for row in rows:
+            row["earnings"] = round(earnings.get(row["group"], 0.0), 2)
+    emit(table, rows, output)
+    # csv, json and plain lines have no room for the caption
+    if footnote and (
+        (output is None and state["plain"])
+        or (output is not None and Path(output).suffix.lower() in (".csv", ".json"))
+    ):
+        err_console.print(footnote, markup=False)
+
+
+def strip_values(df: pd.DataFrame) -> pd.DataFrame:
+    df.columns = df.columns.str.strip()
+    for c in df.columns:
+        try:
+            df[c] = df[c].str.strip()
+        except AttributeError:
+            pass
+    return df
+
+
+def team_summary(
+    filenames: list[str], weeks=TEAM_WEEKS, week_start=DEFAULT_WEEK_START,
+    min_members=TEAM_MIN_MEMBERS,
+) -> list[dict]:
+    """Hours of a team by week and project, from the records file of each member.
+
+    Nobody is named, a week only tells how many members tracked time. Weeks
+    and projects with fewer than `min_members` of them would be someone's
+    hours: such projects are summed as None, untagged sessions included, and
+    left out with the weeks if still too few. Newest first.
+    """
+    group = WeekRef(week_start).group
+    since = clock.now().normalize() - pd.Timedelta(weeks=weeks)
+    totals, active = {}, {}
+    for member, filename in enumerate(filenames):
+        manager = FileManager(filename, lock=False, journal=False)
+        if not manager.exists(create=False):
+            raise FileMissingError(f"File {filename} does not exist.")
+        records = manager.load_all()
+        if not records:
+            continue
+        aggregator = Aggregator('daily', verbose=False)
+        aggregator.calculate(records)
+        for session in aggregator.session_list():
+            if session['start'] < since:
+                continue
+            key = (group(session['start']), project_of(session['notes']))
+            totals[key] = totals.get(key, pd.Timedelta(0)) + session['duration']
+            active.setdefault(key, set()).add(member)
+    rows = []
+    for week in sorted({week for week, _ in totals}, reverse=True):
+        keys = [key for key in totals if key[0] == week]
+        members = set().union(*(active[key] for key in keys))
+        if len(members) < min_members:
+            continue
+        projects, others, other = [], set(), pd.Timedelta(0)
+        for key in keys:
+            if key[1] is not None and len(active[key]) >= min_members:
+                projects.append((key[1], totals[key]))
+            else:
+                others |= active[key]
+                other += totals[key]
+        if len(others) >= min_members:
+            projects.append((None, other))
+        if not projects:
+            continue
+        projects.sort(key=lambda item: item[1], reverse=True)
+        rows.append({
+            "group": week,
+            "duration": sum((d for _, d in projects), pd.Timedelta(0)),
+            "members": len(members),
+            "projects": projects,
+        })
+    return rows
+
+
+class Takt:
+    """
+    Interface for managing and processing data records, primarily focused on
+    file system interactions and data aggregation.
+
+    This class encapsulates functionalities for file management, data
+    retrieval, insertion, and aggregation. It is designed to provide a
+    structured and extendable approach to handling file-based data records.
+
+    Attributes
+    ----------
+    row : FileRow
+        Class representing a row in the data file.
+    filename : str
+        Name of the file to manage.
+    _file_manager : FileManager, optional
+        Internal manager for file operations.
+    _aggregator : Aggregator, optional
+        Internal tool for data aggregation.
+
+    Methods
+    -------
+    file_manager()
+        Accessor for the FileManager instance, lazily initialized.
+    all_rows(nrows=None)
+        Retrieves a list of data records from the file, optionally limited to `nrows`.
+    insert_row(timestamp, kind, notes)
+        Inserts a new row into the file with the given data.
+    first_row()
+        Returns the first data record from the file.
+    aggregate(period="daily")
+        Aggregates data records based on the specified period.
+    register(*args, plugin_name=None, **kwargs)
+        Static method. Registers a new command with an optional plugin name.
+    print_console(msg, style=None)
+        Static method. Prints a message to the console with optional styling.
+
+    Examples
+    --------
+    >>> takt = Takt()
+    >>> takt.insert_row("2023-01-01", "example", "This is a note.")
+    >>> takt.print_console(takt_instance.first_row())
+
+    Notes
+    -----
+    The class is intended to be used in environments where structured
+    file-based data management is required. It is particularly useful for
+    scenarios involving data aggregation and processing.
+    """
+
+
+    def __init__(self):
+        self.row = FileRow
+        self.config = load_config()
+        self.filename = records_file(self.config)
+        self._file_manager = None
+        self._aggregator = None
+        self._git = None
+        self._warned_overlaps = False
+        self._overlap_adjustment = None
+        # False under `takt ui`, git and hooks must not prompt nor print
+        self.interactive = True
+
+    @property
+    def file_manager(self):
+        """Get File manager."""
+        file_manager = self._file_manager
+        if file_manager is None:
+            file_manager = FileManager(
+                self.filename,
+                state["lenient"],
+                state["lock"],
+                self.config.get("backup", {}),
+                self.config.get("journal", True),
+            )
+            self._file_manager = file_manager
+        return file_manager
+
+    @property
+    def git(self):
+        """Get git sync, None if sync is disabled."""
+        if not self.config.get("sync", False):
+            return None
+        if self._git is None:
+            self._git = GitSync(self.filename, prompt=self.interactive)
+        return self._git
+
+    def pull(self):
+        """Pull remote records before changing the file."""
+        if self.git:
+            interactive = (
+                self.interactive and sys.stdin.isatty()
+                and self.config.get("resolve", True)
+            )
+            self.git.pull(resolve_conflicts if interactive else None)
+
+    @property
+    def day_start(self) -> pd.Timedelta:
+        """Time of day when a new day starts, midnight by default."""
+        hour, minute = parse_clock(self.config.get("day_start", "00:00"))
+        return pd.Timedelta(hours=hour, minutes=minute)
+
+    @property
+    def suspicious(self) -> pd.Timedelta:
+        """Sessions longer than this are likely a forgotten check out."""
+        return parse_duration(
+            self.report_options().get("suspicious", SUSPICIOUS_SESSION)
+        )
+
+    @property
+    def split_days(self) -> bool:
+        """Whether sessions crossing `day_start` count for both days."""
+        return self.config.get("split_days", True)
+
+    @property
+    def machine(self) -> str:
+        """Name of this machine in commits and records."""
+        return (
+            os.getenv("TAKT_MACHINE")
+            or self.config.get("machine")
+            or platform.node()
+            or "unknown"
+        )
+
+    def push(self, message, paths=None):
+        """Commit and push the records file."""
+        if self.git:
+            self.git.commit(f"{message} on {self.machine}", paths)
+            tag = None
+            if self.config.get("week_tags", True):
+                tag = self.git.tag_week(clock.now())
+            self.git.push(tags=[tag] if tag else [])
+
+    def all_rows(self, nrows=None) -> list[dict[str, float | str]]:
+        """Return records from file, including archives unless `nrows`."""
+        file_manager = self.file_manager
+        if nrows is None:
+            return self.resolve_overlaps(file_manager.load_all())
+        return file_manager.load(nrows=nrows)
+
+    def resolve_overlaps(self, records):
+        """Apply the `overlaps` setting to the overlaps of `records`.
+
+        `warn` and `error` leave them to `takt doctor`, `merge` and
+        `truncate` repair them for reading, the file is not changed.
+        """
+        mode = self.config.get("overlaps", "warn")
+        if mode not in OVERLAP_MODES:
+            raise InvalidArgsError(
+                f"Overlaps {mode} not supported, use {', '.join(OVERLAP_MODES)}."
+            )
+        overlaps = find_overlaps(records)
+        if not overlaps:
+            return records
+        if mode in OVERLAP_FIXES:
+            repaired = repair_overlaps(records, overlaps, mode)
+            now = clock.now()
+            raw = sum(
+                (s['duration'] for s in pair_sessions(records, now)), pd.Timedelta(0)
+            )
+            kept = sum(
+                (s['duration'] for s in pair_sessions(repaired, now)), pd.Timedelta(0)
+            )
+            self._overlap_adjustment = (
+                f"{len(overlaps)} overlapping sessions {mode}d, "
+                f"{format_time(raw - kept)} of double counted time removed",
+                raw,
+            )
+            return repaired
+        first = overlaps[0]
+        msg = (
+            f"{len(overlaps)} overlapping sessions in {self.filename}, e.g. "
+            f"checked in at {first['ins'][0][TIMESTAMP]} while checked in "
+            f"since {first['first'][TIMESTAMP]}."
+        )
+        hint = "Run `takt doctor` to list them and `--fix` to repair them."
+        if mode == "error":
+            raise ParseError(msg, hint=hint)
+        if not self._warned_overlaps:
+            err_console.print(f"[yellow]WARNING:[/] {msg} {hint}")
+            self._warned_overlaps = True
+        return records
+
+    def insert_row(self, timestamp, kind, notes, meta=""):
+        """Inser row in file."""
+        file_manager = self.file_manager
+        if self.config.get("record_machine", False):
+            meta = parse_meta(meta)
+            meta.setdefault("machine", self.machine)
+            meta = format_meta(meta)
+        file_manager.insert(**self.row(timestamp, kind, notes, meta))
+
+    def first_row(self):
+        """Return first row from file."""
+        file_manager = self.file_manager
+        return file_manager.first()
+
+    def check(
+        self, notes="", meta="", expect=None, idempotent=False, timestamp=None
+    ):
+        """Check in or out depending on the last record.
+
+        With `expect` it fails unless that is the kind to record, or with
+        `idempotent` records nothing and returns `(None, None)`. A backdated
+        `timestamp` can't precede the last check.
+        """
+        self.pull()
+        timestamp = clock.now() if timestamp is None else timestamp
+        # the kind is inferred and written under one lock, two checks at
+        # once can't both check in
+        with self.file_manager.lock():
+            last_kind = self.last_check()
+            if last_kind is not None and timestamp < last_kind[TIMESTAMP]:
+                raise InvalidArgsError(
+                    f"{timestamp} is before the last record, {last_kind[KIND]} "
+                    f"at {last_kind[TIMESTAMP]}."
+                )
+            # infer kind
+            if last_kind is None or last_kind[KIND] == 'out':
+                kind = 'in'
+            else:
+                kind = 'out'
+            if expect is not None and kind != expect:
+                if idempotent:
+                    return None, None
+                state = "in" if kind == 'out' else "out"
+                raise TaktError(
+                    f"Already checked {state}.",
+                    hint="Use `takt check` to toggle or `--idempotent` to "
+                    "ignore it.",
+                )
+            if kind == 'out':
+                # checking out stops the task timers
+                for task in running_tasks(self.file_manager.load()):
+                    self.insert_row(
+                        timestamp, TASK_STOP, "", format_meta({"task": task})
+                    )
+            self.insert_row(timestamp, kind, notes, meta)
+        self.push(f"takt: check {kind} at {timestamp:%Y-%m-%d %H:%M:%S}")
+        return kind, timestamp
+
+    def last_check(self):
+        """Return the newest check or break record."""
+        return last_check(self.file_manager.load())
+
+    def stale_check_in(self):
+        """Return the check in left open longer than `auto_out.after`."""
+        after = self.config.get("auto_out", {}).get("after")
+        if after is None or not self.file_manager.exists(create=False):
+            return None
+        records = self.file_manager.load()
+        last = last_check(records)
+        if last is None or last[KIND] not in CHECKED_IN_KINDS:
+            return None
+        check_in = next(r for r in records if r[KIND] == 'in')
+        if clock.now() - check_in[TIMESTAMP] <= parse_duration(after):
+            return None
+        return check_in
+
+    def auto_out_time(self, check_in) -> pd.Timestamp:
+        """When the stale `check_in` is closed: after a workday."""
+        workday = self.config.get("auto_out", {}).get("workday", WORKDAY)
+        last = last_check(self.file_manager.load())
+        return max(check_in[TIMESTAMP] + parse_duration(workday), last[TIMESTAMP])
+
+    def auto_out(self, check_in) -> pd.Timestamp:
+        """Record the inferred check out of a stale `check_in`."""
+        self.pull()
+        timestamp = self.auto_out_time(check_in)
+        for task in running_tasks(self.file_manager.load()):
+            self.insert_row(timestamp, TASK_STOP, "", format_meta({"task": task}))
+        self.insert_row(
+            timestamp, "out", "Inferred by takt.", format_meta({"inferred": "auto-out"})
+        )
+        self.push(f"takt: inferred check out at {timestamp:%Y-%m-%d %H:%M:%S}")
+        return timestamp
+
+    def compliance_rules(self) -> dict:
+        """Working time rules from the `[compliance]` config."""
+        config = self.config.get("compliance", {})
+        return {
+            "max_weekly_hours": float(
+                config.get("max_weekly_hours", MAX_WEEKLY_HOURS)
+            ),
+            "average_weeks": int(config.get("average_weeks", AVERAGE_WEEKS)),
+            "min_daily_rest": parse_duration(
+                config.get("min_daily_rest", MIN_DAILY_REST)
+            ),
+        }
+
+    def rest_warning(self, timestamp) -> str | None:
+        """Warn when a check in at `timestamp` cuts the daily rest short."""
+        records = self.file_manager.load()
+        last_out = next((r for r in records if r[KIND] == 'out'), None)
+        day_start = self.day_start
+        same_day = (
+            last_out is not None
+            and (last_out[TIMESTAMP] - day_start).date()
+            == (timestamp - day_start).date()
+        )
+        if last_out is None or same_day:
+            return None
+        minimum = self.compliance_rules()["min_daily_rest"]
+        rest = timestamp - last_out[TIMESTAMP]
+        if rest >= minimum:
+            return None
+        return (
+            f"Only {format_short(int(rest.total_seconds()))} of rest since the "
+            f"check out at {last_out[TIMESTAMP]:%Y-%m-%d %H:%M}, the minimum "
+            f"is {format_short(int(minimum.total_seconds()))}."
+        )
+
+    def aggregate(
+        self, period: str = "daily", week_start=None, min_duration=None,
+        recent=None,
+    ) -> list[dict]:
+        """Aggregate records.
+
+        With `recent`, only that many newest groups are needed, the records
+        of the last months are read first, see `FileManager.load_recent`.
+        That slice comes from the records file alone, archives are left out,
+        they hold older years. The groups before the slice aren't computed
+        either, so `adjustments` totals start with it.
+        """
+        week_start = week_start or self.config.get("week_start", DEFAULT_WEEK_START)
+        min_duration = min_duration or self.report_options().get("min_duration")
+        aggregator = Aggregator(
+            period,
+            week_start=week_start,
+            day_start=self.day_start,
+            min_duration=parse_duration(min_duration) if min_duration else None,
+            split_days=self.split_days,
+            suspicious=self.suspicious,
+        )
+        self._aggregator = aggregator
+        if recent is not None and period in RECENT_PERIODS:
+            # every group has records in at least one month, the oldest
+            # group of the slice may be cut so one more is needed
+            records = self.file_manager.load_recent(recent + 1)
+            if records:
+                aggregator.verbose = False
+                rows = aggregator.calculate(self.resolve_overlaps(records))
+                aggregator.verbose = True
+                if len(rows) > recent:
+                    aggregator.warn()
+                    return rows
+        records = self.all_rows()
+        if not records:
+            raise TaktError(f"No records found in {self.filename}.")
+        return aggregator.calculate(records)
+
+    @property
+    def overlap_repair(self) -> str | None:
+        """What the `overlaps` setting changed on read, None if nothing."""
+        if self._overlap_adjustment is None:
+            return None
+        return self._overlap_adjustment[0]
+
+    def adjustments(self) -> dict | None:
+        """Rules that changed the last aggregate, with raw and adjusted totals.
+
+        The totals are of every session aggregated, from `since`, which is
+        only the newest months when `aggregate` read a recent slice. None
+        when the numbers shown are the tracked ones.
+        """
+        aggregator = self._aggregator
+        if aggregator is None:
+            return None
+        rules = list(aggregator.adjustments)
+        raw = aggregator.raw_total
+        if self.overlap_repair is not None:
+            rules.insert(0, self.overlap_repair)
+            raw = self._overlap_adjustment[1]
+        if not rules:
+            return None
+        return {
+            "rules": rules,
+            "raw": raw,
+            "adjusted": pd.Timedelta(aggregator.sessions.duration.sum()),
+            "since": aggregator.sessions.start.min(),
+        }
+
+    def status(self, records=None) -> dict:
+        """Return the current state and today's total, of `records` if given."""
+        records = self.all_rows() if records is None else records
+        now = clock.now()
+        info = {
+            "version": STATUS_VERSION,
+            "state": "out",
+            "since": None,
+            "elapsed_seconds": 0,
+            "today_seconds": 0,
+            "project": None,
+            "notes": "",
+        }
+        if not records:
+            return info
+        last = last_check(records)
+        if last is None:
+            return info
+        if last[KIND] in CHECKED_IN_KINDS:
+            check_in = next(r for r in records if r[KIND] == 'in')
+            info["state"] = "break" if last[KIND] == BREAK_START else "in"
+            info["since"] = check_in[TIMESTAMP].isoformat()
+            info["elapsed_seconds"] = int((now - check_in[TIMESTAMP]).total_seconds())
+            info["project"] = project_of(check_in[NOTES])
+            info["notes"] = check_in[NOTES]
+        else:
+            info["since"] = last[TIMESTAMP].isoformat()
+        today = DailyRef.group(now - self.day_start)
+        aggregator = Aggregator(
+            'daily', verbose=False, day_start=self.day_start,
+            split_days=self.split_days,
+        )
+        for row in aggregator.calculate(records):
+            if row["group"] == today:
+                info["today_seconds"] = int(row["duration"].total_seconds())
+        return info
+
+    def sessions(self, project=None) -> list[dict]:
+        """Every session, oldest first, optionally of one project."""
+        records = self.all_rows()
+        if not records:
+            return []
+        aggregator = Aggregator(
+            'daily', verbose=False, day_start=self.day_start,
+            split_days=self.split_days,
+        )
+        aggregator.calculate(records)
+        sessions = aggregator.session_list()
+        if project is not None:
+            sessions = [s for s in sessions if project_of(s['notes']) == project]
+        return sessions
+
+    def progress(self) -> list[dict]:
+        """Tracked time of the projects and tasks with an estimate."""
+        estimates = self.config.get("estimates", {})
+        projects = estimates.get("projects", {})
+        tasks = estimates.get("tasks", {})
+        rows = []
+        if projects:
+            totals = {}
+            for session in self.sessions():
+                project = project_of(session['notes'])
+                totals[project] = (
+                    totals.get(project, pd.Timedelta(0)) + session['duration']
+                )
+            for project, estimate in projects.items():
+                rows.append({
+                    "type": "project",
+                    "name": project,
+                    "actual": totals.get(project, pd.Timedelta(0)),
+                    "estimate": parse_duration(estimate),
+                })
+        if tasks:
+            totals = task_durations(self.all_rows(), clock.now())
+            for task, estimate in tasks.items():
+                rows.append({
+                    "type": "task",
+                    "name": task,
+                    "actual": totals.get(task, (pd.Timedelta(0), 0))[0],
+                    "estimate": parse_duration(estimate),
+                })
+        return rows
+
+    def daily_totals(self, records=None) -> dict:
+        """Tracked time of every day with records, of `records` if given."""
+        records = self.all_rows() if records is None else records
+        if not records:
+            return {}
+        aggregator = Aggregator(
+            'daily', verbose=False, day_start=self.day_start,
+            split_days=self.split_days,
+        )
+        return {
+            pd.Timestamp(row['group']).date(): row['duration']
+            for row in aggregator.calculate(records)
+        }
+
+    def leave(self) -> dict:
+        """Leave of every day off, see `leave_days`."""
+        return leave_days(self.all_rows())
+
+    def holidays(self, first, last) -> dict:
+        """Public holidays of the `country` in the config between two days."""
+        country = self.config.get("country")
+        if country is None:
+            return {}
+        days = {}
+        for year in range(first.year, last.year + 1):
+            days.update(public_holidays(country, year, self.config.get("region")))
+        return {d: name for d, name in days.items() if first.date() <= d <= last.date()}
+
+    def rate(self, project=None) -> float:
+        """Hourly rate of a project, the `[rates]` default otherwise."""
+        rates = self.config.get("rates", {})
+        rate = rates.get("projects", {}).get(project, rates.get("default"))
+        return None if rate is None else float(rate)
+
+    @property
+    def currency(self) -> str:
+        return self.config.get("rates", {}).get("currency", DEFAULT_CURRENCY)
+
+    def earnings(self) -> dict:
+        """Money earned in each group of the last aggregation."""
+        sessions = self._aggregator.sessions
+        if self.config.get("rates") is None:
+            raise InvalidArgsError("--earnings needs the [rates] config.")
+        out = {}
+        for group, duration, notes in zip(
+            sessions.group, sessions.duration, sessions.notes
+        ):
+            rate = self.rate(project_of(notes[0])) or 0.0
+            out[group] = out.get(group, 0.0) + to_hours(duration) * rate
+        return out
+
+    def report_options(self) -> dict:
+        """The `[report]` settings, `--style` wins over the config."""
+        report = dict(self.config.get("report", {}))
+        report.setdefault("currency", self.currency)
+        if state.get("style"):
+            report["style"] = state["style"]
+        if state.get("humanize"):
+            report["humanize"] = True
+        return report
+
+    def sessions_by_group(self):
+        """Sessions of each group of the last aggregation."""
+        return self._aggregator.sessions_by_group()
+
+    def top_notes(self, n: int):
+        """Top `n` notes by time of the last aggregation, None if n is 0."""
+        if not n:
+            return None
+        return self._aggregator.top_notes(n)
+
+    @staticmethod
+    def register(*args, plugin_name=None, **kwargs):
+        """Decorator to register a new Takt command.
+
+        It uses the typer command decorator. So use the same signature. This
+        means that you can use this decorator to register a new command in
+        typer as well.
+        """
+        if plugin_name is None:
+            raise ValueError("plugin_name must be provided.")
+        # ensure rich help panel is not duplicated
+        kwargs = {**kwargs, "rich_help_panel": plugin_name}
+        return app.command(*args, **kwargs)
+
+    @staticmethod
+    def print_console(msg, style=None):
+        """Print message to console."""
+        console.print(msg, style=style)
+
+
+def record_to_json(record: dict) -> dict:
+    return {**record, TIMESTAMP: record[TIMESTAMP].isoformat()}
+
+
+def summary_to_json(row: dict) -> dict:
+    return {
+        "group": row["group"],
+        "hours": to_hours(row["duration"]),
+        "days": sorted(date.isoformat() for date in row["dates"]),
+        "notes": sorted(note for note in row["notes"] if note),
+        "suspicious": int(row.get("suspicious", 0)),
+    }
+
+
+def ics_escape(text: str) -> str:
+    text = str(text).replace("\\", "\\\\").replace("\n", "\\n")
+    return text.replace(",", "\\,").replace(";", "\\;")
+
+
+def sessions_to_ics(sessions: list[dict], name: str) -> str:
+    """Render sessions as an iCalendar feed."""
+    stamp = clock.now().strftime("%Y%m%dT%H%M%S")
+    lines = [
+        "BEGIN:VCALENDAR",
+        "VERSION:2.0",
+        "PRODID:-//takt//takt//EN",
+        f"X-WR-CALNAME:{ics_escape(name)}",
+        "REFRESH-INTERVAL;VALUE=DURATION:PT15M",
+        "X-PUBLISHED-TTL:PT15M",
+    ]
+    for session in sessions:
+        start = session['start']
+        title = project_of(session['notes']) or name
+        summary = f"{title} ({format_time(session['duration'])})"
+        uid = hashlib.sha256(f"{start.isoformat()}{name}".encode()).hexdigest()
+        lines += [
+            "BEGIN:VEVENT",
+            f"UID:{uid[:32]}@takt",
+            f"DTSTAMP:{stamp}",
+            f"DTSTART:{start:%Y%m%dT%H%M%S}",
+            f"DTEND:{session['end']:%Y%m%dT%H%M%S}",
+            f"SUMMARY:{ics_escape(summary)}",
+            f"DESCRIPTION:{ics_escape(session['notes'])}",
+            "END:VEVENT",
+        ]
+    lines.append("END:VCALENDAR")
+    return "\r\n".join(lines) + "\r\n"
+
+
+GOOGLE_CALENDAR = "https://calendar.google.com/calendar/ical/{}/public/basic.ics"
+ICS_LINE = re.compile(r'((?:[^:"]|"[^"]*")*):(.*)')
+
+
+def ics_unescape(text: str) -> str:
+    return re.sub(
+        r"\\(.)", lambda m: "\n" if m.group(1) in "nN" else m.group(1), text
+    )
+
+
+def ics_properties(text: str) -> list[dict]:
+    """Properties of each VEVENT, name to a list of (params, value).
+
+    Components nested in an event, like alarms, are left out.
+    """
+    lines = []
+    for line in text.splitlines():
+        # long lines are folded, the continuation starts with a space
+        if line[:1] in (" ", "\t") and lines:
+            lines[-1] += line[1:]
+        else:
+            lines.append(line)
+    events, event, depth = [], None, 0
+    for line in lines:
+        match = ICS_LINE.fullmatch(line)
+        if match is None:
+            continue
+        name, *params = match.group(1).split(";")
+        name, value = name.upper(), match.group(2)
+        if name == "BEGIN":
+            if value == "VEVENT" and event is None:
+                event = {}
+            elif event is not None:
+                depth += 1
+        elif name == "END" and event is not None:
+            if depth:
+                depth -= 1
+            elif value == "VEVENT":
+                events.append(event)
+                event = None
+        elif event is not None and not depth:
+            params = dict(p.partition("=")[::2] for p in params)
+            params = {key.upper(): value for key, value in params.items()}
+            event.setdefault(name, []).append((params, value))
+    return events
+
+
+def ics_time(params: dict, value: str) -> pd.Timestamp | None:
+    """Local time of an iCalendar date-time, None for all-day dates.
+
+    Times without a zone, or with one unknown here, are taken as local.
+    """
+    if params.get("VALUE") == "DATE" or "T" not in value:
+        return None
+    moment = datetime.strptime(value.strip().rstrip("Z"), "%Y%m%dT%H%M%S")
+    zone = None
+    if value.strip().endswith("Z"):
+        zone = timezone.utc
+    elif "TZID" in params:
+        try:
+            zone = zoneinfo.ZoneInfo(params["TZID"].strip('"'))
+        except (zoneinfo.ZoneInfoNotFoundError, ValueError):
+            pass
+    if zone is not None:
+        moment = moment.replace(tzinfo=zone).astimezone().replace(tzinfo=None)
+    return pd.Timestamp(moment)
+
+
+def calendar_events(text: str, start, end) -> list[dict]:
+    """Timed events of an iCalendar feed overlapping `start` to `end`.
+
+    Recurring events are expanded, cancelled, free and all-day ones left out.
+    """
+    events = ics_properties(text)
+
+    def first(event, name):
+        params, value = event.get(name, [({}, "")])[0]
+        return params, value.strip()
+
+    # occurrences of a recurring event moved or cancelled on their own
+    moved = {
+        (first(e, "UID")[1], ics_time(*first(e, "RECURRENCE-ID")))
+        for e in events if "RECURRENCE-ID" in e
+    }
+    found = []
+    for event in events:
+        if "DTSTART" not in event:
+            continue
+        if first(event, "STATUS")[1].upper() == "CANCELLED":
+            continue
+        if first(event, "TRANSP")[1].upper() == "TRANSPARENT":
+            continue
+        begin = ics_time(*first(event, "DTSTART"))
+        if begin is None:
+            continue
+        if "DTEND" in event:
+            length = ics_time(*first(event, "DTEND")) - begin
+        elif "DURATION" in event:
+            length = pd.Timedelta(first(event, "DURATION")[1])
+        else:
+            continue
+        uid = first(event, "UID")[1]
+        starts = [begin]
+        if "RRULE" in event and "RECURRENCE-ID" not in event:
+            # the rule is expanded in local time, as DTSTART
+            rule = re.sub(r"(UNTIL=[0-9T]+)Z", r"\1", first(event, "RRULE")[1])
+            rule = rrulestr(rule, dtstart=begin.to_pydatetime())
+            skipped = {
+                ics_time(params, day)
+                for params, values in event.get("EXDATE", [])
+                for day in values.split(",")
+            }
+            starts = [
+                pd.Timestamp(s)
+                for s in rule.between(
+                    (start - length).to_pydatetime(), end.to_pydatetime(), inc=True
+                )
+            ]
+            starts = [
+                s for s in starts if s not in skipped and (uid, s) not in moved
+            ]
+        title = ics_unescape(first(event, "SUMMARY")[1]) or "meeting"
+        found += [
+            {"start": s, "end": s + length, "title": title, "uid": uid}
+            for s in starts
+            if length > pd.Timedelta(0) and s < end and s + length > start
+        ]
+    return sorted(found, key=lambda e: e["start"])
+
+
+def sessions_to_json(sessions: list[dict]) -> list[dict]:
+    return [
+        {
+            "start": s['start'].isoformat(),
+            "end": s['end'].isoformat(),
+            "hours": to_hours(s['duration']),
+            "notes": s['notes'],
+            "inferred": bool(s.get('inferred', False)),
+            "with": s.get('coworkers', []),
+        }
+        for s in sessions
+    ]
+
+
+def hourly_totals(sessions, start, end) -> dict:
+    """Seconds tracked in every hour from `start` to `end`."""
+    start, end = start.floor("h"), end.ceil("h")
+    hours = pd.date_range(start, end, freq="h", inclusive="left")
+    totals = {hour: 0.0 for hour in hours}
+    for session in sessions:
+        begin, finish = max(session['start'], start), min(session['end'], end)
+        hour = begin.floor("h")
+        while hour < finish:
+            following = hour + pd.Timedelta(hours=1)
+            overlap = min(following, finish) - max(hour, begin)
+            totals[hour] += overlap.total_seconds()
+            hour = following
+    return totals
+
+
+class MetricsHistory:
+    """Hourly totals of the last days, recomputed only when files change."""
+
+    def __init__(self, days=METRICS_DAYS):
+        self.days = days
+        self.lock = threading.Lock()
+        self.version = None
+        self.totals = None
+
+    def files_version(self, file_manager):
+        paths = [file_manager.filename]
+        paths += [m.filename for m in file_manager.archive_managers()]
+        return tuple(
+            (path, os.path.getmtime(path)) for path in paths if os.path.exists(path)
+        )
+
+    def get(self, takt) -> dict:
+        with self.lock:
+            version = self.files_version(takt.file_manager)
+            now = clock.now()
+            # also refresh every hour so the window keeps moving
+            version += (now.floor("h"),)
+            if version != self.version:
+                sessions = takt.sessions()
+                self.totals = hourly_totals(
+                    sessions, now - pd.Timedelta(days=self.days), now
+                )
+                self.version = version
+            return self.totals
+
+
+def feed_signature(secret: str, project: str) -> str:
+    return hmac.new(secret.encode(), project.encode(), hashlib.sha256).hexdigest()
+
+
+def bearer_token(handler):
+    header = handler.headers.get("Authorization", "")
+    scheme, _, token = header.partition(" ")
+    return token.strip() if scheme.lower() == "bearer" else None
+
+
+class NoAuth:
+    def authenticate(self, handler):
+        return "anonymous"
+
+
+class TokenAuth:
+    """Static bearer token."""
+
+    def __init__(self, token):
+        self.token = token
+        self.identity = "token:" + hashlib.sha256(token.encode()).hexdigest()[:8]
+
+    def authenticate(self, handler):
+        token = bearer_token(handler) or ""
+        if hmac.compare_digest(token, self.token):
+            return self.identity
+        return None
+
+
+def hash_password(password, salt=None, iterations=PASSWORD_ITERATIONS) -> str:
+    """Salted pbkdf2 hash of `password`, as `[serve.basic.users]` stores it."""
+    salt = os.urandom(16) if salt is None else salt
+    digest = hashlib.pbkdf2_hmac("sha256", password.encode(), salt, iterations)
+    return f"{PASSWORD_SCHEME}${iterations}${salt.hex()}${digest.hex()}"
+
+
+def check_password(password, stored) -> bool:
+    _, iterations, salt, _ = stored.split("$")
+    computed = hash_password(password, bytes.fromhex(salt), int(iterations))
+    return hmac.compare_digest(computed, stored)
+
+
+def is_password_hash(stored) -> bool:
+    parts = stored.split("$") if isinstance(stored, str) else ()
+    if len(parts) != 4 or parts[0] != PASSWORD_SCHEME:
+        return False
+    try:
+        return int(parts[1]) > 0 and bool(bytes.fromhex(parts[2]))
+    except ValueError:
+        return False
+
+
+class BasicAuth:
+    """HTTP basic auth, users map to a salted hash, see `hash_password`."""
+
+    def __init__(self, users: dict):
+        for user, stored in users.items():
+            if not is_password_hash(stored):
+                raise InvalidArgsError(
+                    f"Password of {user} is not a {PASSWORD_SCHEME} hash.",
+                    hint="Create one with `takt hash-password`.",
+                )
+        self.users = users
+        # unknown users take as long to reject as a wrong password
+        self.unknown = hash_password(uuid.uuid4().hex)
+
+    def authenticate(self, handler):
+        header = handler.headers.get("Authorization", "")
+        scheme, _, credentials = header.partition(" ")
+        if scheme.lower() != "basic":
+            return None
+        try:
+            decoded = base64.b64decode(credentials).decode()
+        except (ValueError, UnicodeDecodeError):
+            return None
+        user, _, password = decoded.partition(":")
+        valid = check_password(password, self.users.get(user, self.unknown))
+        if not valid or user not in self.users:
+            return None
+        return user
+
+
+class OIDCAuth:
+    """Bearer tokens validated against the userinfo endpoint of an issuer.
+
+    Identities are cached for `OIDC_CACHE` seconds, up to `OIDC_CACHE_SIZE`
+    tokens, the oldest are dropped first.
+    """
+
+    def __init__(self, issuer):
+        self.issuer = issuer.rstrip("/")
+        self._endpoint = None
+        self._cache = {}
+        # requests are served in threads
+        self._lock = threading.Lock()
+
+    @property
+    def endpoint(self):
+        if self._endpoint is None:
+            url = f"{self.issuer}/.well-known/openid-configuration"
+            with urllib.request.urlopen(url, timeout=5) as response:
+                self._endpoint = json.load(response)["userinfo_endpoint"]
+        return self._endpoint
+
+    def authenticate(self, handler):
+        token = bearer_token(handler)
+        if not token:
+            return None
+        with self._lock:
+            identity, expires = self._cache.get(token, (None, 0))
+        if expires > time.monotonic():
+            return identity
+        request = urllib.request.Request(
+            self.endpoint, headers={"Authorization": f"Bearer {token}"}
+        )
+        try:
+            with urllib.request.urlopen(request, timeout=5) as response:
+                info = json.load(response)
+        except (OSError, ValueError):
+            return None
+        identity = info.get("email") or info.get("sub")
+        self.remember(token, identity)
+        return identity
+
+    def remember(self, token, identity):
+        now = time.monotonic()
+        with self._lock:
+            self._cache.pop(token, None)
+            if len(self._cache) >= OIDC_CACHE_SIZE:
+                self._cache = {
+                    k: v for k, v in self._cache.items() if v[1] > now
+                }
+            while len(self._cache) >= OIDC_CACHE_SIZE:
+                # dicts keep insertion order, the first expires first
+                del self._cache[next(iter(self._cache))]
+            self._cache[token] = (identity, now + OIDC_CACHE)
+
+
+class MTLSAuth:
+    """Client certificates, verified by the TLS layer, identified by CN."""
+
+    def authenticate(self, handler):
+        cert = handler.connection.getpeercert()
+        if not cert:
+            return None
+        for rdn in cert.get("subject", ()):
+            for key, value in rdn:
+                if key == "commonName":
+                    return value
+        return None
+
+
+class TaktHandler(BaseHTTPRequestHandler):
+    """REST API over the records file.
+
+    GET  /records?limit=N     newest records first
+    GET  /summary?period=P    summary by daily, wtd, mtd, quarter, half or ytd
+    GET  /export?format=F     every record, including archives, json or csv
+    GET  /backup              zip with the records, archives and journal
+    POST /check               check in or out, body: {"notes": "..."}
+    POST /restore             replace the records with a /backup zip
+    GET  /healthz             the server is up, no credentials needed
+    GET  /readyz              the records file can be read or is still to
+                              be created, 503 otherwise
+    GET  /calendar.ics?sig=S  every session as iCalendar, see `takt feed-url`
+    GET  /team?weeks=N        hours by project and week of `[serve.team]`
+    """
+
+    authenticator = NoAuth()
+    identity = None
+    feed_secret = None
+    allow_restore = False
+    metrics = None
+    # records files of `[serve.team]`, the team page is off without them
+    team = None
+    # set on shutdown, ends the event streams
+    stopping = threading.Event()
+
+    def do_GET(self):
+        path = urlparse(self.path).path
+        if path in ("/healthz", "/readyz"):
+            return self.serve_health(path)
+        if path == "/events":
+            return self.stream_events()
+        if path in ("/backup", "/export"):
+            return self.guarded(lambda: self.serve_download(path))
+        if path == CALENDAR_FEED or path.startswith(FEED_PREFIX):
+            return self.guarded(self.serve_feed)
+        self.handle_request(self.get_routes())
+
+    def do_POST(self):
+        self.handle_request(self.post_routes())
+
+    def get_routes(self):
+        routes = {"/records": self.get_records, "/summary": self.get_summary}
+        if self.metrics is not None:
+            routes["/metrics/history"] = self.get_metrics_history
+        if self.team is not None:
+            routes["/team"] = self.get_team
+        return routes
+
+    def post_routes(self):
+        return {"/check": self.post_check, "/restore": self.post_restore}
+
+    def handle_request(self, routes):
+        url = urlparse(self.path)
+        if not self.authorized():
+            return self.send_json(401, {"error": "Invalid or missing credentials."})
+        route = routes.get(url.path)
+        if route is None:
+            return self.send_json(404, {"error": f"{url.path} not found."})
+        query = {k: v[-1] for k, v in parse_qs(url.query).items()}
+        self.guarded(lambda: self.send_json(200, route(query)))
+
+    def guarded(self, respond):
+        """Call `respond`, answering the errors it raises with JSON."""
+        try:
+            respond()
+        except InvalidArgsError as e:
+            self.send_json(400, {"error": str(e)})
+        except Exception:
+            # the details stay in the server log, file and git errors show
+            # paths and git output
+            self.log_error("%s", traceback.format_exc())
+            self.send_json(500, {"error": "Internal server error."})
+
+    def authorized(self):
+        self.identity = self.authenticator.authenticate(self)
+        return self.identity is not None
+
+    def read_json(self):
+        length = int(self.headers.get("Content-Length") or 0)
+        if not length:
+            return {}
+        try:
+            return json.loads(self.rfile.read(length))
+        except json.JSONDecodeError as e:
+            raise InvalidArgsError(f"Invalid JSON body: {e}")
+
+    def send_json(self, status, body):
+        payload = json.dumps(body).encode()
+        self.send_response(status)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(payload)))
+        self.end_headers()
+        self.wfile.write(payload)
+
+    def send_bytes(self, status, payload, content_type, filename=None):
+        self.send_response(status)
+        self.send_header("Content-Type", content_type)
+        self.send_header("Content-Length", str(len(payload)))
+        if filename is not None:
+            self.send_header(
+                "Content-Disposition", f'attachment; filename="{filename}"'
+            )
+        self.end_headers()
+        self.wfile.write(payload)
+
+    def serve_download(self, path):
+        """Full backup or export of the records."""
+        if not self.authorized():
+            return self.send_json(401, {"error": "Invalid or missing credentials."})
+        t = Takt()
+        stamp = f"{clock.now():%Y%m%d-%H%M%S}"
+        if path == "/backup":
+            payload = t.file_manager.export_zip()
+            return self.send_bytes(
+                200, payload, "application/zip", f"takt-{stamp}.zip"
+            )
+        query = {k: v[-1] for k, v in parse_qs(urlparse(self.path).query).items()}
+        fmt = query.get("format", "json")
+        records = t.all_rows()
+        if fmt == "json":
+            return self.send_json(200, [record_to_json(r) for r in records])
+        if fmt != "csv":
+            return self.send_json(400, {"error": f"Format {fmt} not supported."})
+        payload = records_to_csv(records, COLUMNS + EXTENDED_COLUMNS).encode()
+        self.send_bytes(200, payload, "text/csv", f"takt-{stamp}.csv")
+
+    def serve_health(self, path):
+        """Probes for container runtimes, they tell nothing about the records."""
+        if self.stopping.is_set():
+            return self.send_json(503, {"status": "stopping"})
+        if path == "/readyz":
+            filename = Takt().filename
+            # a new volume has no records until the first check
+            if os.path.exists(filename) and not os.access(filename, os.R_OK):
+                error = f"{filename} can't be read."
+                return self.send_json(
+                    503, {"status": "unavailable", "error": error}
+                )
+        self.send_json(200, {"status": "ok"})
+
+    def stream_events(self):
+        """Send every record, archives included, on every change.
+
+        Clients diff it with the previous one, so deletes reach them too.
+        """
+        if not self.authorized():
+            return self.send_json(401, {"error": "Invalid or missing credentials."})
+        self.send_response(200)
+        self.send_header("Content-Type", "text/event-stream")
+        self.send_header("Cache-Control", "no-cache")
+        self.end_headers()
+        filename = Takt().filename
+        last_mtime, sent = None, False
+        last_sent = time.monotonic()
+        try:
+            while not self.stopping.is_set():
+                try:
+                    mtime = os.path.getmtime(filename)
+                except FileNotFoundError:
+                    # no records until the first check
+                    mtime = None
+                if not sent or mtime != last_mtime:
+                    last_mtime, sent = mtime, True
+                    try:
+                        records = (
+                            Takt().file_manager.load_all()
+                            if mtime is not None else []
+                        )
+                    except TaktError:
+                        # sent again on the change that fixes the file
+                        self.log_error("%s", traceback.format_exc())
+                        continue
+                    data = json.dumps([record_to_json(r) for r in records])
+                    self.wfile.write(f"event: records\ndata: {data}\n\n".encode())
+                    self.wfile.flush()
+                    last_sent = time.monotonic()
+                elif time.monotonic() - last_sent > EVENT_KEEPALIVE:
+                    self.wfile.write(b": keep-alive\n\n")
+                    self.wfile.flush()
+                    last_sent = time.monotonic()
+                time.sleep(1)
+        except (BrokenPipeError, ConnectionResetError):
+            return
+
+    def serve_feed(self):
+        """Read-only feed of a project, or every session, by its signature."""
+        url = urlparse(self.path)
+        if url.path == CALENDAR_FEED:
+            name, project, ext = ALL_SESSIONS, None, "ics"
+        else:
+            name, _, ext = url.path[len(FEED_PREFIX):].rpartition(".")
+            project = name
+        query = {k: v[-1] for k, v in parse_qs(url.query).items()}
+        signature = query.get("sig", "")
+        if not self.feed_secret or not hmac.compare_digest(
+            signature, feed_signature(self.feed_secret, name)
+        ):
+            return self.send_json(403, {"error": "Invalid feed signature."})
+        sessions = Takt().sessions(project=project)
+        if ext == "json":
+            total = sum((s['duration'] for s in sessions), pd.Timedelta(0))
+            body = {
+                "project": name,
+                "hours": to_hours(total),
+                "sessions": sessions_to_json(sessions),
+            }
+            return self.send_json(200, body)
+        if ext != "ics":
+            return self.send_json(404, {"error": f"{url.path} not found."})
+        payload = sessions_to_ics(sessions, project or "takt").encode()
+        self.send_response(200)
+        self.send_header("Content-Type", "text/calendar; charset=utf-8")
+        self.send_header("Content-Length", str(len(payload)))
+        self.end_headers()
+        self.wfile.write(payload)
+
+    def get_records(self, query):
+        try:
+            limit = int(query.get("limit", 10))
+        except ValueError:
+            raise InvalidArgsError("limit must be an integer.")
+        records = Takt().all_rows(nrows=limit)
+        return [record_to_json(record) for record in records]
+
+    def get_summary(self, query):
+        period = query.get("period", "daily")
+        rows = Takt().aggregate(period=period)
+        return [summary_to_json(row) for row in rows]
+
+    def get_metrics_history(self, query):
+        try:
+            days = int(query.get("days", self.metrics.days))
+        except ValueError:
+            raise InvalidArgsError("days must be an integer.")
+        totals = self.metrics.get(Takt())
+        since = clock.now().floor("h") - pd.Timedelta(days=days)
+        return {
+            "hours": [h.isoformat() for h in totals if h >= since],
+            "seconds": [s for h, s in totals.items() if h >= since],
+        }
+
+    def get_team(self, query):
+        try:
+            weeks = int(query.get("weeks", TEAM_WEEKS))
+        except ValueError:
+            raise InvalidArgsError("weeks must be an integer.")
+        week_start = load_config().get("week_start", DEFAULT_WEEK_START)
+        return [
+            {
+                "week": row['group'],
+                "members": len(self.team),
+                "participants": row['members'],
+                "hours": to_hours(row['duration']),
+                "projects": [
+                    {"project": project, "hours": to_hours(duration)}
+                    for project, duration in row['projects']
+                ],
+            }
+            for row in team_summary(self.team, weeks, week_start)
+        ]
+
+    def provenance(self):
+        """Metadata identifying who wrote a record through the API."""
+        meta = {
+            "source": "api",
+            "client": self.headers.get("X-Takt-Client")
+            or self.headers.get("User-Agent", ""),
+            "ip": self.client_address[0],
+            "user": self.identity,
+        }
+        # keep the metadata parseable
+        return format_meta({k: re.sub(r"[;=]", " ", v) for k, v in meta.items()})
+
+    def post_restore(self, query):
+        if not self.allow_restore:
+            raise InvalidArgsError(
+                "Restore is disabled, start the server with --allow-restore."
+            )
+        length = int(self.headers.get("Content-Length") or 0)
+        t = Takt()
+        restored = t.file_manager.restore_zip(self.rfile.read(length))
+        t.push("takt: restore from the API", list(restored))
+        return {"restored": restored}
+
+    def post_check(self, query):
+        body = self.read_json()
+        kind, timestamp = Takt().check(body.get("notes", ""), self.provenance())
+        return {KIND: kind, TIMESTAMP: timestamp.isoformat()}
+
+
+def is_loopback(host) -> bool:
+    """Whether `host` is only reachable from this machine."""
+    if host == "localhost":
+        return True
+    try:
+        return ipaddress.ip_address(host).is_loopback
+    except ValueError:
+        return False
+
+
+def make_authenticator(auth, token, config):
+    """Build the authenticator for `takt serve` from flags and config."""
+    if auth is None:
+        return NoAuth()
+    if auth == "token":
+        token = token or config.get("token")
+        if not token:
+            raise InvalidArgsError("token auth needs `--token` or TAKT_TOKEN.")
+        return TokenAuth(token)
+    if auth == "basic":
+        users = config.get("basic", {}).get("users", {})
+        if not users:
+            raise InvalidArgsError("basic auth needs [serve.basic.users].")
+        return BasicAuth(users)
+    if auth == "oidc":
+        issuer = config.get("oidc", {}).get("issuer")
+        if not issuer:
+            raise InvalidArgsError("oidc auth needs [serve.oidc] issuer.")
+        return OIDCAuth(issuer)
+    if auth == "mtls":
+        return MTLSAuth()
+    raise InvalidArgsError(
+        f"Auth {auth} not supported, use {', '.join(AUTH_METHODS)}."
+    )
+
+
+def read_events(stream):
+    """Yield (event, data) pairs from a server-sent events stream."""
+    event, data = "message", []
+    for line in stream:
+        line = line.decode().rstrip("\r\n")
+        if not line:
+            if data:
+                yield event, "\n".join(data)
+            event, data = "message", []
+        elif line.startswith(":"):
+            continue
+        elif line.startswith("event:"):
+            event = line[len("event:"):].strip()
+        elif line.startswith("data:"):
+            data.append(line[len("data:"):].strip())
+
+
+def project_names(config: dict) -> set:
+    """Projects set up in the config, with a rate, an estimate or a table."""
+    names = set(config.get("projects", {}))
+    for table in ("rates", "estimates"):
+        names.update(config.get(table, {}).get("projects", {}))
+    return names
+
+
+def complete_projects(incomplete: str) -> list[str]:
+    """Shell completion of the projects in the records and the config."""
+    try:
+        t = Takt()
+        projects = {project_of(r[NOTES]) for r in t.all_rows()}
+    except TaktError:
+        return []
+    projects.update(project_names(t.config))
+    return sorted(p for p in projects if p and p.startswith(incomplete))
+
+
+def complete_profiles(incomplete: str) -> list[str]:
+    """Shell completion of the profiles in the config."""
+    profiles = load_config().get("profiles", {})
+    return sorted(p for p in profiles if p.startswith(incomplete))
+
+
+OUTPUT_OPTION = typer.Option(
+    None, "--output", "-o",
+    help="Write to this file, the format follows its extension.",
+)
+PER_NOTE_TOP_OPTION = typer.Option(
+    0, help="Show the N notes with most time in each period."
+)
+NOTES_OPTION = typer.Option(
+    False, "--notes", help="Show the notes of each period."
+)
+MIN_DURATION_OPTION = typer.Option(
+    None, help="Hide sessions shorter than this, e.g. 5m."
+)
+EARNINGS_OPTION = typer.Option(
+    False, "--earnings", help="Show the money earned, see [rates]."
+)
+
+NOTE_WORDS_ARGUMENT = typer.Argument(
+    None, metavar="[NOTE]...", help="Note of the record, no quotes needed."
+)
+NOTE_OPTION = typer.Option("", "--notes", "--note", "-m", help="Note of the record.")
+EDIT_NOTE_OPTION = typer.Option(
+    False, "--edit-note", help="Write the note in $EDITOR."
+)
+WITH_OPTION = typer.Option(
+    None, "--with", help="Co-workers you pair with, e.g. ana,bob."
+)
+IDEMPOTENT_OPTION = typer.Option(
+    False, help="Record nothing, without failing, if already in that state."
+)
+AT_OPTION = typer.Option(
+    None, help="When it happened, e.g. 09:12 today or '2024-07-01 09:12'."
+)
+AGO_OPTION = typer.Option(None, help="How long ago it happened, e.g. 15m.")
+PRINT_STATE_OPTION = typer.Option(
+    False, help="Only print the resulting state, in or out, for scripts."
+)
+
+
+def read_note(words, note="", edit=False) -> str:
+    """Join the note words and options, optionally editing the result."""
+    text = " ".join([note, *(words or [])]).strip()
+    if edit:
+        template = f"{text}\n# Lines starting with '#' are ignored.\n"
+        edited = typer.edit(template)
+        if edited is None:
+            raise TaktError("Note not saved, nothing recorded.")
+        lines = [
+            line for line in edited.splitlines() if not line.startswith("#")
+        ]
+        text = "\n".join(lines).strip()
+    return text
+
+
+def resolve_conflicts(base, remote, local):
+    """Merge both sides of a pull, asking about the conflicting records."""
+    merged, conflicts = merge_records(base, remote, local)
+    if not conflicts:
+        return merged
+    console.print(
+        f"[yellow]{len(conflicts)} records changed on both sides.[/] "
+        "Pick the version to keep, r for remote and l for local."
+    )
+    for remote_record, local_record in conflicts:
+        table = Table(show_header=True, header_style="bold magenta")
+        for column in ("Side", "Timestamp", "Kind", "Notes", "Meta"):
+            table.add_column(column)
+        for side, record in (("remote", remote_record), ("local", local_record)):
+            if record is None:
+                table.add_row(side, "deleted", "", "", "")
+            else:
+                table.add_row(
+                    side,
+                    str(record[TIMESTAMP]),
+                    record[KIND],
+                    record[NOTES],
+                    record.get(META) or "",
+                )
+        console.print(table)
+        choice = typer.prompt(
+            "Keep", type=click.Choice(["r", "l"]), default="l"
+        )
+        record = remote_record if choice == "r" else local_record
+        if record is not None:
+            merged.append(record)
+    return sorted(merged, key=lambda r: r[TIMESTAMP], reverse=True)
+
+
+def run_hook(t, name, record):
+    """Run the `[hooks]` command `name` after a change was written.
+
+    Hooks run once the lock is released, so they can call takt again.
+    `TAKT_SNAPSHOT` is a copy of the records as they were written, and hooks
+    started by hooks are not run.
+    """
+    command = t.config.get("hooks", {}).get(name)
+    if not command or os.getenv("TAKT_HOOK"):
+        return
+    env = {
+        "TAKT_HOOK": name,
+        "TAKT_KIND": record[KIND],
+        "TAKT_TIMESTAMP": record[TIMESTAMP].isoformat(),
+        "TAKT_NOTES": record[NOTES],
+    }
+    profile = active_profile(t.config)
+    if profile is not None:
+        env["TAKT_PROFILE"] = profile
+    else:
+        env["TAKT_FILE"] = t.filename
+    with tempfile.TemporaryDirectory() as tmp:
+        snapshot = os.path.join(tmp, Path(t.filename).name)
+        shutil.copyfile(t.filename, snapshot)
+        # under `takt ui` the output would garble the screen
+        output = None if t.interactive else subprocess.DEVNULL
+        out = subprocess.run(
+            command, shell=True,
+            env={**os.environ, **env, "TAKT_SNAPSHOT": snapshot},
+            stdout=output, stderr=output,
+        )
+        if out.returncode != 0:
+            enqueue_hook(name, command, env, snapshot, out.returncode)
+            err_console.print(
+                f"[yellow]Warning:[/] hook {name} exited with "
+                f"{out.returncode}, queued to retry, see `takt queue list`."
+            )
+
+
+def load_queue() -> list[dict]:
+    """Return the hooks waiting to be retried, oldest first."""
+    if not Path(QUEUE_FILE).exists():
+        return []
+    return json.loads(Path(QUEUE_FILE).read_text())
+
+
+def save_queue(entries: list[dict]):
+    os.makedirs(QUEUE_DIR, exist_ok=True)
+    write_atomic(QUEUE_FILE, json.dumps(entries, indent=2) + "\n")
+
+
+def queue_lock() -> FileLock:
+    """Lock of the queue, its directory is created on first use."""
+    os.makedirs(QUEUE_DIR, exist_ok=True)
+    return FileLock(QUEUE_FILE)
+
+
+def queue_backoff(attempts: int) -> pd.Timedelta:
+    """Wait before the next retry, doubling with every failed attempt."""
+    seconds = min(QUEUE_BACKOFF * 2 ** (attempts - 1), QUEUE_BACKOFF_MAX)
+    return pd.Timedelta(seconds=seconds)
+
+
+def enqueue_hook(name, command, env, snapshot, returncode):
+    """Queue a failed hook with a copy of its snapshot to retry it later.
+
+    Hooks push events elsewhere, webhooks or chat, so a network error must
+    not lose them, `takt daemon` retries the queue with `queue_backoff`.
+    """
+    now = clock.now()
+    entry = {
+        "id": uuid.uuid4().hex[:8],
+        "hook": name,
+        "command": command,
+        "env": env,
+        "created": now.isoformat(),
+        "attempts": 1,
+        "next": (now + queue_backoff(1)).isoformat(),
+        "error": f"exited with {returncode}",
+    }
+    os.makedirs(QUEUE_DIR, exist_ok=True)
+    entry["snapshot"] = os.path.join(
+        QUEUE_DIR, f"{entry['id']}-{Path(snapshot).name}"
+    )
+    shutil.copyfile(snapshot, entry["snapshot"])
+    with queue_lock():
+        save_queue([*load_queue(), entry])
+
+
+def drop_queued(entry):
+    if "snapshot" in entry:
+        Path(entry["snapshot"]).unlink(missing_ok=True)
+
+
+def retry_queue(ids=None, force=False) -> tuple[int, int]:
+    """Run the queued hooks that are due, or `ids`, return done and failed.
+
+    `force` ignores the backoff. Hooks that fail again stay queued, up to
+    `QUEUE_MAX_ATTEMPTS` attempts, then they are dropped with a warning.
+    """
+    done = failed = 0
+    with queue_lock():
+        entries = load_queue()
+        now = clock.now()
+        kept = []
+        for entry in entries:
+            due = force or pd.Timestamp(entry["next"]) <= now
+            if (ids is not None and entry["id"] not in ids) or not due:
+                kept.append(entry)
+                continue
+            if "presence" in entry:
+                error = retry_presence(entry)
+            else:
+                out = subprocess.run(
+                    entry["command"], shell=True,
+                    env={
+                        **os.environ, **entry["env"],
+                        "TAKT_SNAPSHOT": entry["snapshot"],
+                    },
+                )
+                error = f"exited with {out.returncode}" if out.returncode else None
+            if error is None:
+                drop_queued(entry)
+                done += 1
+                continue
+            entry["attempts"] += 1
+            entry["next"] = (now + queue_backoff(entry["attempts"])).isoformat()
+            entry["error"] = error
+            failed += 1
+            if entry["attempts"] >= QUEUE_MAX_ATTEMPTS:
+                drop_queued(entry)
+                err_console.print(
+                    f"[yellow]Warning:[/] hook {entry['hook']} dropped after "
+                    f"{entry['attempts']} attempts, {error}."
+                )
+                continue
+            kept.append(entry)
+        if done or failed:
+            save_queue(kept)
+    return done, failed
+
+
+class Notifier:
+    """A service showing others whether I'm checked in, e.g. a chat status.
+
+    Subclasses implement `show` and `clear`, raising `OSError` or `TaktError`
+    when the service can't be reached so the change is queued. Plugins add
+    services to `NOTIFIERS`, configured in `[presence.NAME]`.
+    """
+
+    def __init__(self, config: dict):
+        self.config = config
+
+    @property
+    def token(self) -> str:
+        token = self.config.get("token")
+        if not token:
+            raise InvalidArgsError("`token` missing.")
+        return token
+
+    def status(self, notes: str) -> str:
+        """Status text from the `status` template of the config."""
+        template = self.config.get("status", PRESENCE_STATUS)
+        try:
+            return template.format(notes=notes, project=project_of(notes) or "")
+        except (KeyError, IndexError, ValueError):
+            raise InvalidArgsError(
+                f"Status {template!r} not supported, use {{notes}} and {{project}}."
+            )
+
+    def validate(self, notes: str):
+        """Fail on a config that can't work, before the update is queued."""
+        if self.token:
+            self.status(notes)
+
+    def update(self, kind: str, notes: str):
+        """Show the status on check in, clear it on check out."""
+        if kind == 'in':
+            self.show(self.status(notes))
+        else:
+            self.clear()
+
+    def show(self, status: str):
+        raise NotImplementedError
+
+    def clear(self):
+        raise NotImplementedError
+
+    def post(self, url, payload) -> dict:
+        """POST `payload` as JSON with the token, return the JSON answer."""
+        request = urllib.request.Request(
+            url,
+            data=json.dumps(payload).encode(),
+            headers={
+                "Authorization": f"Bearer {self.token}",
+                "Content-Type": "application/json; charset=utf-8",
+            },
+            method="POST",
+        )
+        with urllib.request.urlopen(request, timeout=5) as response:
+            body = response.read()
+        return json.loads(body) if body else {}
+
+
+class SlackNotifier(Notifier):
+    """Slack profile status, the token needs the `users.profile:write` scope."""
+
+    url = "https://slack.com/api/users.profile.set"
+
+    def set_profile(self, text, emoji):
+        answer = self.post(self.url, {
+            "profile": {
+                "status_text": text,
+                "status_emoji": emoji,
+                "status_expiration": 0,
+            }
+        })
+        if not answer.get("ok"):
+            raise TaktError(f"Slack answered {answer.get('error', 'not ok')}.")
+
+    def show(self, status):
+        self.set_profile(status, self.config.get("emoji", PRESENCE_EMOJI))
+
+    def clear(self):
+        self.set_profile("", "")
+
+
+class TeamsNotifier(Notifier):
+    """Teams status message, the token needs the `Presence.ReadWrite` scope."""
+
+    url = "https://graph.microsoft.com/v1.0/me/presence/setStatusMessage"
+
+    def set_message(self, text):
+        self.post(self.url, {
+            "statusMessage": {"message": {"content": text, "contentType": "text"}}
+        })
+
+    def show(self, status):
+        self.set_message(status)
+
+    def clear(self):
+        self.set_message("")
+
+
+NOTIFIERS = {"slack": SlackNotifier, "teams": TeamsNotifier}
+
+
+def notifier(name, config) -> Notifier:
+    if name not in NOTIFIERS:
+        raise InvalidArgsError(
+            f"Presence {name} not supported, use {', '.join(NOTIFIERS)}."
+        )
+    return NOTIFIERS[name](config)
+
+
+def update_presence(t, kind, notes):
+    """Update the `[presence]` services after a check in or out.
+
+    The updates are queued and sent by a detached `takt queue retry`, so the
+    check never waits for the network. One that fails stays queued for
+    `takt daemon`, a newer one replaces it so a late retry never shows a
+    stale status.
+    """
+    if kind not in ('in', 'out'):
+        return
+    ids = []
+    for name, config in t.config.get("presence", {}).items():
+        try:
+            notifier(name, config).validate(notes)
+        except InvalidArgsError as e:
+            err_console.print(f"[yellow]Warning:[/] presence {name}: {e}")
+            continue
+        ids.append(enqueue_presence(name, kind, notes))
+    if ids:
+        send_queued(ids)
+
+
+def send_queued(ids):
+    """Retry the queue entries `ids` in the background, without waiting."""
+    subprocess.Popen(
+        [sys.executable, os.path.abspath(__file__), "queue", "retry", *ids],
+        stdin=subprocess.DEVNULL,
+        stdout=subprocess.DEVNULL,
+        stderr=subprocess.DEVNULL,
+        start_new_session=True,
+    )
+
+
+def enqueue_presence(name, kind, notes, error=None) -> str:
+    """Queue a presence update, replacing older ones of `name`, return its id.
+
+    Without `error` it wasn't tried yet and is due now. The token isn't
+    queued, retries read it from the config.
+    """
+    now = clock.now()
+    entry = {
+        "id": uuid.uuid4().hex[:8],
+        "hook": f"presence.{name}",
+        "presence": name,
+        "kind": kind,
+        "notes": notes,
+        "created": now.isoformat(),
+        "attempts": 0 if error is None else 1,
+        "next": (now if error is None else now + queue_backoff(1)).isoformat(),
+        "error": error or "",
+    }
+    with queue_lock():
+        entries = [e for e in load_queue() if e.get("presence") != name]
+        save_queue([*entries, entry])
+    return entry["id"]
+
+
+def retry_presence(entry) -> str | None:
+    """Send a queued presence update again, return the error if it fails."""
+    config = load_config().get("presence", {}).get(entry["presence"])
+    if config is None:
+        return f"[presence.{entry['presence']}] not in the config"
+    try:
+        notifier(entry["presence"], config).update(entry["kind"], entry["notes"])
+    except (OSError, ValueError, TaktError) as e:
+        return str(e)
+    return None
+
+
+def taskwarrior(*args) -> str:
+    """Run a Taskwarrior command, return its output.
+
+    `TAKT_TASKWARRIOR` tells the takt hook the change comes from takt.
+    """
+    cmd = ["task", "rc.confirmation=off", "rc.verbose=nothing", *args]
+    env = {**os.environ, TASKWARRIOR_ENV: "1"}
+    try:
+        out = subprocess.run(
+            cmd, stdout=subprocess.PIPE, stderr=subprocess.PIPE, text=True,
+            env=env,
+        )
+    except FileNotFoundError:
+        raise TaktError("`task` not found, check if Taskwarrior is installed.")
+    if out.returncode != 0:
+        raise TaktError(f"Taskwarrior failed: {out.stderr.strip()}")
+    return out.stdout.strip()
+
+
+def taskwarrior_task(task: str) -> dict:
+    """Return the Taskwarrior task with id or uuid `task`."""
+    tasks = json.loads(taskwarrior(task, "export") or "[]")
+    if not tasks:
+        raise InvalidArgsError(f"Taskwarrior task {task} not found.")
+    return tasks[0]
+
+
+def close_stale_session():
+    """Offer to check out a session left open, see `[auto_out]`."""
+    t = Takt()
+    check_in = t.stale_check_in()
+    if check_in is None:
+        return
+    timestamp = t.auto_out_time(check_in)
+    if t.config.get("auto_out", {}).get("confirm", True):
+        if not sys.stdin.isatty():
+            return
+        question = (
+            f"You checked in at {check_in[TIMESTAMP]:%Y-%m-%d %H:%M} and never "
+            f"checked out. Record the check out at {timestamp:%Y-%m-%d %H:%M}?"
+        )
+        if not typer.confirm(question, default=True):
+            return
+    timestamp = t.auto_out(check_in)
+    t.print_console(
+        f"Check [bold magenta]OUT[/] inferred at {timestamp}", style="yellow"
+    )
+
+
+@app.callback()
+def options(
+    ctx: typer.Context,
+    lenient: bool = typer.Option(
+        False, help="Skip records with invalid timestamps instead of failing."
+    ),
+    lock: bool = typer.Option(
+        True, help="Lock the records file while it is being rewritten."
+    ),
+    profile: str = typer.Option(
+        None, envvar="TAKT_PROFILE", help="Profile whose records file to use.",
+        autocompletion=complete_profiles,
+    ),
+    style: str = typer.Option(
+        None, help="How summaries are rendered: table, plain or tsv."
+    ),
+    json_errors: bool = typer.Option(
+        False, "--json", help="Report errors as JSON on stderr."
+    ),
+    pager: bool = typer.Option(
+        True, help="Page output longer than the terminal."
+    ),
+    humanize: bool = typer.Option(
+        False, help="Spell out durations, e.g. `3 hours 12 minutes`."
+    ),
+    plain: bool = typer.Option(
+        False, help="Label: value lines for screen readers, no tables."
+    ),
+    trace_: bool = typer.Option(
+        False, "--trace", help="Print the time spent in each phase on stderr."
+    ),
+):
+    """
+    Takt is a CLI tool for tracking time.
+    """
+    state["lenient"] = lenient
+    state["lock"] = lock
+    state["profile"] = profile
+    state["style"] = style
+    state["json"] = json_errors
+    state["pager"] = pager
+    state["humanize"] = humanize
+    state["trace"] = trace_
+    # screen readers: no boxes, padding nor colors
+    state["plain"] = (
+        plain or load_config().get("plain", False) or os.getenv("TERM") == "dumb"
+    )
+    if state["plain"]:
+        console.no_color = err_console.no_color = True
+    if ctx.invoked_subcommand in AUTO_OUT_COMMANDS:
+        close_stale_session()
+
+
+@app.command()
+def check(
+    words: list[str] = NOTE_WORDS_ARGUMENT,
+    notes: str = NOTE_OPTION,
+    edit_note: bool = EDIT_NOTE_OPTION,
+    task: str = typer.Option(
+        None, "--task", help="Taskwarrior task to start or stop with the check."
+    ),
+    with_: str = WITH_OPTION,
+    at: str = AT_OPTION,
+    ago: str = AGO_OPTION,
+    print_state: bool = PRINT_STATE_OPTION,
+):
+    """
+    Check in or out.
+    """
+    run_check(
+        words, notes, edit_note, task, with_, at=at, ago=ago,
+        print_state=print_state,
+    )
+
+
+@app.command("in")
+def check_in(
+    words: list[str] = NOTE_WORDS_ARGUMENT,
+    notes: str = NOTE_OPTION,
+    edit_note: bool = EDIT_NOTE_OPTION,
+    task: str = typer.Option(
+        None, "--task", help="Taskwarrior task to start with the check in."
+    ),
+    with_: str = WITH_OPTION,
+    idempotent: bool = IDEMPOTENT_OPTION,
+    at: str = AT_OPTION,
+    ago: str = AGO_OPTION,
+    print_state: bool = PRINT_STATE_OPTION,
+):
+    """
+    Check in, failing if already checked in.
+    """
+    run_check(
+        words, notes, edit_note, task, with_, 'in', idempotent, at, ago,
+        print_state,
+    )
+
+
+@app.command("out")
+def check_out(
+    words: list[str] = NOTE_WORDS_ARGUMENT,
+    notes: str = NOTE_OPTION,
+    edit_note: bool = EDIT_NOTE_OPTION,
+    task: str = typer.Option(
+        None, "--task", help="Taskwarrior task to stop with the check out."
+    ),
+    idempotent: bool = IDEMPOTENT_OPTION,
+    at: str = AT_OPTION,
+    ago: str = AGO_OPTION,
+    print_state: bool = PRINT_STATE_OPTION,
+):
+    """
+    Check out, failing if not checked in.
+    """
+    run_check(
+        words, notes, edit_note, task, None, 'out', idempotent, at, ago,
+        print_state,
+    )
+
+
+def run_check(
+    words, notes, edit_note, task, with_, expect=None, idempotent=False,
+    at=None, ago=None, print_state=False,
+):
+    """Record a check, of the `expect` kind only if given, see `Takt.check`.
+
+    With `print_state` only the resulting state, `in` or `out`, is printed on
+    stdout, so a script can branch on it without running `takt status`.
+    """
+    timestamp = backdated(at, ago)
+    notes = read_note(words, notes, edit_note)
+    t = Takt()
+    meta = {}
+    last = t.last_check()
+    checking_in = last is None or last[KIND] == 'out'
+    if with_ is not None:
+        # `takt in` reports being checked in itself
+        if not checking_in and expect is None:
+            raise InvalidArgsError("--with is only for checking in.")
+        names = [name.strip() for name in with_.split(",") if name.strip()]
+        meta["with"] = ",".join(dict.fromkeys(names))
+    if task is not None:
+        found = taskwarrior_task(task)
+        meta["taskwarrior"] = found["uuid"]
+        if checking_in:
+            notes = f"{notes} {found['description']}".strip()
+    if expect is None and (with_ is not None or task is not None):
+        # read without the lock, `check` fails if another check came first
+        expect = 'in' if checking_in else 'out'
+    kind, timestamp = t.check(
+        notes, format_meta(meta), expect, idempotent, timestamp
+    )
+    if kind is None:
+        if print_state:
+            typer.echo(expect)
+        else:
+            t.print_console(f"Already checked {expect}, nothing recorded.")
+        return
+    if print_state:
+        typer.echo(kind)
+    else:
+        t.print_console(
+            f"Check [bold magenta]{kind.upper()}[/] at {timestamp}", style="green"
+        )
+    if task is not None:
+        action = "start" if kind == 'in' else "stop"
+        try:
+            taskwarrior(found["uuid"], action)
+        except TaktError as e:
+            # the check is already recorded and pushed, don't fail it
+            err_console.print(
+                f"[yellow]Warning:[/] {e} (run `task {task} {action}` by hand)"
+            )
+        else:
+            if not print_state:
+                t.print_console(
+                    f"Taskwarrior task {task} "
+                    f"{'started' if kind == 'in' else 'stopped'}"
+                )
+    if kind == 'in':
+        warning = t.rest_warning(timestamp)
+        if warning is not None:
+            err_console.print(f"[yellow]Warning:[/] {warning}")
+    run_hook(t, "post_check", {TIMESTAMP: timestamp, KIND: kind, NOTES: notes})
+    update_presence(t, kind, notes)
+
+
+def format_short(seconds: int) -> str:
+    h, m = divmod(seconds // 60, 60)
+    return f"{h}h{m:02d}m" if h else f"{m}m"
+
+
+def read_current_session(filename):
+    """Return (state, check in row) reading only the newest lines.
+
+    It avoids pandas so it is cheap enough to run on every prompt.
+    """
+    try:
+        f = open(filename, 'rb')
+    except FileNotFoundError:
+        return "out", None
+    with f:
+        state = None
+        for _, row in iter_rows(f):
+            if row.get(KIND, "") in SIDE_KINDS:
+                continue
+            if state is None:
+                if row.get(KIND) not in CHECKED_IN_KINDS:
+                    return "out", None
+                state = "break" if row[KIND] == BREAK_START else "in"
+            if row.get(KIND) == "in":
+                return state, row
+    return "out", None
+
+
+def session_start(value: str):
+    """Check in time of the prompt as naive local time, None if unreadable.
+
+    It reads the forms the records reader does, see `parse_legacy_timestamp`.
+    """
+    try:
+        since = parse_legacy_timestamp(value)
+        if since is None:
+            since = pd.Timestamp(value)
+    except (ValueError, TypeError, OverflowError):
+        return None
+    if pd.isna(since):
+        return None
+    if since.tzinfo is not None:
+        local = since.to_pydatetime().astimezone()
+        since = pd.Timestamp(local.replace(tzinfo=None))
+    return since
+
+
+@app.command()
+def prompt(
+    format: str = typer.Option(
+        PROMPT_FORMAT, "--format", "-f",
+        help="Template with {state}, {elapsed}, {since}, {project}, {notes}.",
+    ),
+):
+    """
+    Print a one line status for shell prompts, nothing when checked out.
+    """
+    state, row = read_current_session(records_file())
+    if row is None:
+        return
+    since = session_start(row.get(TIMESTAMP, ""))
+    # a broken prompt is worse than an empty one
+    if since is None:
+        return
+    elapsed = int((clock.now() - since).total_seconds())
+    typer.echo(format.format(
+        state=state,
+        elapsed=format_short(elapsed),
+        since=since.strftime("%H:%M"),
+        project=project_of(row.get(NOTES)) or "",
+        notes=row.get(NOTES, ""),
+    ))
+
+
+def format_status(info: dict, humanize=False) -> str:
+    fmt = humanize_duration if humanize else format_time
+    today = fmt(pd.Timedelta(seconds=info["today_seconds"]))
+    if info["state"] == "out":
+        return f"out, today {today}"
+    elapsed = fmt(pd.Timedelta(seconds=info["elapsed_seconds"]))
+    project = f" +{info['project']}" if info["project"] else ""
+    return f"{info['state']}{project} {elapsed}, today {today}"
+
+
+@app.command()
+def status(
+    format: str = typer.Option(
+        "text", "--format", "-f", help="Output format: text, json or lsp-json."
+    ),
+    output: str = OUTPUT_OPTION,
+):
+    """
+    Show whether you are checked in and today's total.
+    """
+    t = Takt()
+    info = t.status()
+    text = format_status(info, t.report_options().get("humanize", False))
+    if output is not None:
+        emit(text, info, output)
+    elif format == "text":
+        typer.echo(text)
+    elif format == "json":
+        typer.echo(json.dumps(info, indent=2))
+    elif format == "lsp-json":
+        typer.echo(json.dumps({**info, "text": text}))
+    else:
+        raise InvalidArgsError(f"Format {format} not supported.")
+
+
+@app.command()
+def remind(
+    interval: str = typer.Option(
+        None, help="Remind after this long without a break, e.g. 2h30m."
+    ),
+    snooze: str = typer.Option(
+        None, help="Wait this long before reminding again."
+    ),
+):
+    """
+    Remind you to take a break during long sessions.
+    """
+    t = Takt()
+    config = t.config.get("remind", {})
+    interval = parse_duration(interval or config.get("interval", REMIND_INTERVAL))
+    snooze = parse_duration(snooze or config.get("snooze", REMIND_SNOOZE))
+    t.print_console(
+        f"Reminding you to take a break every {format_short(int(interval.total_seconds()))}.",
+        style="green",
+    )
+    session_start = remind_at = None
+    while True:
+        last = t.last_check()
+        # time since the check in or the end of the last break
+        if last is None or last[KIND] not in ('in', BREAK_END):
+            session_start = remind_at = None
+        elif last[TIMESTAMP] != session_start:
+            session_start = last[TIMESTAMP]
+            remind_at = session_start + interval
+        now = clock.now()
+        if remind_at is not None and now >= remind_at:
+            session = format_short(int((now - session_start).total_seconds()))
+            notify(
+                "takt",
+                f"You've been checked in {session} — take a break (`takt break`).",
+            )
+            remind_at = now + snooze
+        try:
+            time.sleep(REMIND_POLL)
+        except KeyboardInterrupt:
+            return
+
+
+@app.command()
+def pomodoro(
+    work: str = typer.Argument(None, help="Length of a work cycle, e.g. 25m."),
+    break_: str = typer.Argument(
+        None, metavar="[BREAK]", help="Length of a break, e.g. 5m."
+    ),
+    cycles: int = typer.Option(0, help="Stop after this many cycles, 0 runs until Ctrl-C."),
+    notes: str = "",
+):
+    """
+    Run work/break cycles, recording the breaks.
+    """
+    t = Takt()
+    config = t.config.get("pomodoro", {})
+    work = parse_duration(work or config.get("work", POMODORO_WORK))
+    break_ = parse_duration(break_ or config.get("break", POMODORO_BREAK))
+    if cycles < 0:
+        raise InvalidArgsError("--cycles can't be negative.")
+
+    def record(kind):
+        t.pull()
+        timestamp = clock.now()
+        t.insert_row(timestamp, kind, notes)
+        t.push(f"takt: {kind} at {timestamp:%Y-%m-%d %H:%M:%S}")
+
+    def checked_in():
+        last = t.last_check()
+        return last is not None and last[KIND] in CHECKED_IN_KINDS
+
+    last = t.last_check()
+    if last is None or last[KIND] == 'out':
+        kind, _ = t.check(notes)
+        t.print_console(f"Check [bold magenta]{kind.upper()}[/]", style="green")
+    elif last[KIND] == BREAK_START:
+        record(BREAK_END)
+    cycle = 0
+    try:
+        while not cycles or cycle < cycles:
+            cycle += 1
+            t.print_console(
+                f"Cycle {cycle}: work for {format_short(int(work.total_seconds()))}",
+                style="green",
+            )
+            time.sleep(work.total_seconds())
+            # stop if you checked out in the meantime
+            if not checked_in():
+                break
+            record(BREAK_START)
+            notify("takt", f"Cycle {cycle} done — take a break.")
+            time.sleep(break_.total_seconds())
+            if not checked_in():
+                break
+            record(BREAK_END)
+            notify("takt", "Break is over — back to work.")
+    except KeyboardInterrupt:
+        pass
+    last = t.last_check()
+    if last is not None and last[KIND] == BREAK_START:
+        record(BREAK_END)
+    t.print_console(f"Pomodoro stopped after {cycle} cycle(s).", style="green")
+
+
+def parse_schedule(text: str) -> tuple[set, int, int]:
+    """Parse `Mon 09:00`, `Mon-Fri 18:00`, `Sat,Sun 10:00` or `daily 02:00`.
+
+    Return the weekdays, Monday is 0, hour and minute.
+    """
+    days, _, clock_time = str(text).strip().rpartition(" ")
+    hour, minute = parse_clock(clock_time)
+    days = days.strip().lower() or "daily"
+    if days in SCHEDULE_DAYS:
+        return SCHEDULE_DAYS[days], hour, minute
+    weekdays = set()
+    for part in days.split(","):
+        first, _, last = part.strip().partition("-")
+        if first[:3] not in DAY_NAMES or (last and last[:3] not in DAY_NAMES):
+            raise InvalidArgsError(
+                f"Invalid schedule {text!r}, use e.g. 'Mon 09:00', "
+                "'Mon-Fri 18:00' or 'daily 02:00'."
+            )
+        start = DAY_NAMES.index(first[:3])
+        end = DAY_NAMES.index(last[:3]) if last else start
+        if start <= end:
+            weekdays.update(range(start, end + 1))
+        else:
+            # ranges like Sat-Mon wrap around the week
+            weekdays.update([*range(start, 7), *range(end + 1)])
+    return weekdays, hour, minute
+
+
+@app.command()
+def daemon():
+    """
+    Run the `[[schedule]]` entries of the config at their time.
+
+    Failed hooks and presence updates are retried too, waiting longer after
+    each failure.
+    """
+    t = Takt()
+    entries = t.config.get("schedule", [])
+    if not entries and not t.config.get("hooks") and not t.config.get("presence"):
+        raise TaktError(
+            "No [[schedule]] entries, [hooks] or [presence] in the config."
+        )
+    schedule = []
+    for entry in entries:
+        if "run" not in entry and "notify" not in entry:
+            raise InvalidArgsError(
+                f"Schedule {entry.get('at')!r} needs `run` or `notify`."
+            )
+        schedule.append((parse_schedule(entry.get("at", "")), entry))
+    t.print_console(f"Running {len(schedule)} scheduled entries.", style="green")
+    last_minute = None
+    while True:
+        now = clock.now().floor("min")
+        if now != last_minute:
+            last_minute = now
+            for (weekdays, hour, minute), entry in schedule:
+                due = (now.hour, now.minute) == (hour, minute)
+                if not due or now.dayofweek not in weekdays:
+                    continue
+                if "notify" in entry:
+                    notify("takt", entry["notify"])
+                if "run" in entry:
+                    t.print_console(f"{now:%Y-%m-%d %H:%M} {entry['run']}")
+                    out = subprocess.run(entry["run"], shell=True)
+                    if out.returncode != 0:
+                        err_console.print(
+                            f"[yellow]Warning:[/] `{entry['run']}` exited "
+                            f"with {out.returncode}."
+                        )
+            done, failed = retry_queue()
+            if done or failed:
+                t.print_console(
+                    f"{now:%Y-%m-%d %H:%M} retried hooks: {done} done, "
+                    f"{failed} failed."
+                )
+        try:
+            # wake up right after the next minute starts
+            time.sleep(60 - time.time() % 60 + 0.5)
+        except KeyboardInterrupt:
+            return
+
+
+@app.command("notify")
+def notify_cmd(
+    max_session: str = typer.Option(
+        None, help="Warn when a session lasts longer than this, e.g. 10h."
+    ),
+    end_of_day: str = typer.Option(
+        None, help="Warn when still checked in after this time, e.g. 19:00."
+    ),
+    snooze: str = typer.Option(
+        None, help="Wait this long before warning again."
+    ),
+):
+    """
+    Warn about long sessions and forgotten check outs.
+    """
+    t = Takt()
+    config = t.config.get("notify", {})
+    max_session = parse_duration(
+        max_session or config.get("max_session", NOTIFY_MAX_SESSION)
+    )
+    end_of_day = end_of_day or config.get("end_of_day")
+    if end_of_day is not None:
+        end_of_day = parse_clock(end_of_day)
+    snooze = parse_duration(snooze or config.get("snooze", NOTIFY_SNOOZE))
+    t.print_console(
+        "Watching for sessions longer than "
+        f"{format_short(int(max_session.total_seconds()))}.",
+        style="green",
+    )
+    check_in = notify_at = None
+    while True:
+        records = t.file_manager.load()
+        last = last_check(records)
+        now = clock.now()
+        if last is None or last[KIND] not in CHECKED_IN_KINDS:
+            check_in = notify_at = None
+        else:
+            started = next(r for r in records if r[KIND] == 'in')[TIMESTAMP]
+            if started != check_in:
+                check_in = started
+                notify_at = check_in + max_session
+                if end_of_day is not None:
+                    hour, minute = end_of_day
+                    closing = check_in.normalize() + pd.Timedelta(
+                        hours=hour, minutes=minute
+                    )
+                    # a check in after the end of the day has no deadline
+                    if closing > check_in:
+                        notify_at = min(notify_at, closing)
+        if notify_at is not None and now >= notify_at:
+            session = format_short(int((now - check_in).total_seconds()))
+            notify(
+                "takt",
+                f"Still checked in after {session} — forgot `takt check`?",
+            )
+            notify_at = now + snooze
+        try:
+            time.sleep(REMIND_POLL)
+        except KeyboardInterrupt:
+            return
+
+
+def heatmap_cell(duration, target) -> tuple[str, str]:
+    """Character and color of a day, red when well over the target."""
+    if duration is None or duration <= pd.Timedelta(0):
+        return "·", HEATMAP_COLORS[0]
+    ratio = duration / target
+    if ratio > HEATMAP_OVERWORK:
+        return "■", "red"
+    level = min(int(ratio * (len(HEATMAP_COLORS) - 1)) + 1, len(HEATMAP_COLORS) - 1)
+    return "■", HEATMAP_COLORS[level]
+
+
+@app.command()
+def heatmap(
+    year: int = typer.Argument(None, help="Year to show, the current one by default."),
+    month: int = typer.Option(None, min=1, max=12, help="Only show this month."),
+    week_start: str = typer.Option(None, help="First day of the week."),
+):
+    """
+    Calendar heatmap of the hours of each day.
+    """
+    t = Takt()
+    today = clock.now()
+    year = year or today.year
+    week_start = week_start or t.config.get("week_start", DEFAULT_WEEK_START)
+    if week_start not in WEEK_STARTS:
+        raise InvalidArgsError(
+            f"Week start {week_start} not supported, use sunday or monday."
+        )
+    if month is None:
+        first, last = pd.Timestamp(year, 1, 1), pd.Timestamp(year, 12, 31)
+    else:
+        first = pd.Timestamp(year, month, 1)
+        last = first + pd.offsets.MonthEnd(0)
+    target = parse_duration(t.report_options().get("target", DAILY_TARGET))
+    totals = t.daily_totals()
+
+    # one column per week, the first starts on the week start before `first`
+    offset = (first.dayofweek + (1 if week_start == "sunday" else 0)) % 7
+    start = first - pd.Timedelta(days=offset)
+    weeks = (last - start).days // 7 + 1
+    # month names above the week of their 1st day
+    header = [" "] * (5 + weeks * 2 + 2)
+    for week in range(weeks):
+        week_days = [start + pd.Timedelta(days=week * 7 + i) for i in range(7)]
+        for day in week_days:
+            if first <= day <= last and (day.day == 1 or day == first):
+                header[5 + week * 2:5 + week * 2 + 3] = f"{day:%b}"
+    lines = [Text("".join(header).rstrip())]
+    names = ["Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"]
+    if week_start == "monday":
+        names = names[1:] + names[:1]
+    for weekday in range(7):
+        name = names[weekday]
+        line = Text(f"{name}  " if name in ("Mon", "Wed", "Fri") else "     ")
+        for week in range(weeks):
+            day = start + pd.Timedelta(days=week * 7 + weekday)
+            if not first <= day <= last or day > today:
+                line.append("  ")
+                continue
+            char, color = heatmap_cell(totals.get(day.date()), target)
+            line.append(f"{char} ", style=color)
+        lines.append(line)
+    legend = Text("\n     less ")
+    for color in HEATMAP_COLORS:
+        legend.append("■ ", style=color)
+    legend.append("more  ")
+    legend.append("■", style="red")
+    legend.append(f" over {format_short(int(target.total_seconds() * HEATMAP_OVERWORK))}")
+    lines.append(legend)
+    title = f"{first:%B %Y}" if month else str(year)
+    total = sum(
+        (d for day, d in totals.items() if first.date() <= day <= last.date()),
+        pd.Timedelta(0),
+    )
+    console.print(f"[bold magenta]{title}[/] {format_time(total)} hours")
+    console.print(Group(*lines))
+
+
+def hbar(value: float, top: float, width: int, target: float = None) -> str:
+    """Horizontal bar of `value` out of `top` in `width` cells.
+
+    Eighth blocks draw the fraction of the last cell and `│` marks `target`
+    where the bar doesn't reach it.
+    """
+    cells = value / top * width if top else 0
+    full, eighths = int(cells), int((cells - int(cells)) * 8)
+    bar = "█" * full + (BAR_EIGHTHS[eighths] if eighths else "")
+    if target is not None:
+        mark = min(round(target / top * width), width)
+        bar = bar.ljust(mark + 1)
+        if bar[mark] == " ":
+            bar = bar[:mark] + "│" + bar[mark + 1:]
+    return bar.rstrip()
+
+
+@app.command()
+def chart(
+    period: str = typer.Option("daily", help="One bar per day (daily) or week."),
+    last: int = typer.Option(
+        None, min=1, help="Number of bars, 14 days or 8 weeks by default."
+    ),
+    width: int = typer.Option(40, min=10, help="Width of the longest bar."),
+    week_start: str = typer.Option(None, help="First day of the week."),
+    output: str = OUTPUT_OPTION,
+):
+    """
+    Hours of each day or week as bars, with the target marked.
+    """
+    if period not in CHART_PERIODS:
+        raise InvalidArgsError(
+            f"Period {period} not supported, use {', '.join(CHART_PERIODS)}."
+        )
+    t = Takt()
+    week_start = week_start or t.config.get("week_start", DEFAULT_WEEK_START)
+    if week_start not in WEEK_STARTS:
+        raise InvalidArgsError(
+            f"Week start {week_start} not supported, use sunday or monday."
+        )
+    target = parse_duration(t.report_options().get("target", DAILY_TARGET))
+    totals = t.daily_totals()
+    today = clock.now().normalize()
+    if period == "daily":
+        step, count, label = pd.Timedelta(days=1), last or 14, "%a %Y-%m-%d"
+        current = today
+    else:
+        step, count, label = pd.Timedelta(weeks=1), last or 8, "week of %Y-%m-%d"
+        # a week of target hours is the target of its five workdays
+        target *= 5
+        offset = (today.dayofweek + (1 if week_start == "sunday" else 0)) % 7
+        current = today - pd.Timedelta(days=offset)
+    starts = [current - step * n for n in range(count - 1, -1, -1)]
+    durations = [
+        sum(
+            (d for day, d in totals.items()
+             if start.date() <= day < (start + step).date()),
+            pd.Timedelta(0),
+        )
+        for start in starts
+    ]
+    top = max(durations + [target]).total_seconds()
+    table = Table(show_header=True, header_style="bold magenta", box=None)
+    table.add_column("Period", style="dim")
+    table.add_column("Hours", style="dim", justify="right")
+    table.add_column(f"target {format_time(target)} │")
+    rows = []
+    for start, duration in zip(starts, durations):
+        bar = hbar(duration.total_seconds(), top, width, target.total_seconds())
+        # the current day or week is not over yet
+        if duration >= target:
+            color = "green"
+        else:
+            color = "red" if start + step <= today else ""
+        table.add_row(f"{start:{label}}", format_time(duration), Text(bar, style=color))
+        rows.append({"period": start.date().isoformat(), "hours": to_hours(duration)})
+    emit(table, rows, output)
+
+
+def burnup_rows(totals, leave, holidays, first, last, target, today):
+    """Cumulative hours of each day of a month against the target line.
+
+    The target grows by `target` every workday and leave counts as tracked,
+    as in `balance`. Days after `today` have no hours yet.
+    """
+    rows = []
+    tracked = expected = pd.Timedelta(0)
+    for day in pd.date_range(first, last):
+        if day.dayofweek < 5 and day.date() not in holidays:
+            expected += target
+        if day <= today:
+            tracked += totals.get(day.date(), pd.Timedelta(0))
+            tracked += leave.get(day.date(), ("", pd.Timedelta(0)))[1]
+        rows.append({
+            "date": day.date(),
+            "hours": to_hours(tracked) if day <= today else None,
+            "target_hours": to_hours(expected),
+        })
+    return rows
+
+
+def burnup_svg(rows, title) -> str:
+    """Line chart of `burnup_rows`, the target dashed."""
+    width, height = BURNUP_SVG_SIZE
+    pad = 40
+    top = max([r["target_hours"] for r in rows] + [
+        r["hours"] for r in rows if r["hours"] is not None
+    ]) or 1
+    step = (width - 2 * pad) / max(len(rows) - 1, 1)
+
+    def point(i, hours):
+        y = height - pad - hours / top * (height - 2 * pad)
+        return f"{pad + i * step:.1f},{y:.1f}"
+
+    target = " ".join(point(i, r["target_hours"]) for i, r in enumerate(rows))
+    tracked = " ".join(
+        point(i, r["hours"]) for i, r in enumerate(rows) if r["hours"] is not None
+    )
+    return (
+        f'<svg xmlns="http://www.w3.org/2000/svg" width="{width}" '
+        f'height="{height}" font-family="sans-serif" font-size="12">\n'
+        f'<text x="{pad}" y="20">{html.escape(title)}</text>\n'
+        f'<line x1="{pad}" y1="{height - pad}" x2="{width - pad}" '
+        f'y2="{height - pad}" stroke="grey"/>\n'
+        f'<text x="{pad}" y="{height - pad + 16}">{rows[0]["date"]}</text>\n'
+        f'<text x="{width - pad}" y="{height - pad + 16}" text-anchor="end">'
+        f'{rows[-1]["date"]}</text>\n'
+        f'<text x="{pad - 4}" y="{pad}" text-anchor="end">{top:.0f}h</text>\n'
+        f'<polyline points="{target}" fill="none" stroke="grey" '
+        'stroke-dasharray="4 4"/>\n'
+        f'<polyline points="{tracked}" fill="none" stroke="green" '
+        'stroke-width="2"/>\n'
+        "</svg>\n"
+    )
+
+
+def display_burnup(rows, title, width, output=None):
+    """Cumulative hours as bars with the target line marked, or an SVG."""
+    if output is not None and Path(output).suffix.lower() == ".svg":
+        write_atomic(output, burnup_svg(rows, title))
+        return
+    top = max([r["target_hours"] for r in rows] + [
+        r["hours"] for r in rows if r["hours"] is not None
+    ])
+    table = Table(
+        title=title, show_header=True, header_style="bold magenta", box=None
+    )
+    table.add_column("Day", style="dim")
+    table.add_column("Hours", style="dim", justify="right")
+    table.add_column("Target", style="dim", justify="right")
+    table.add_column("│ target line")
+    for row in rows:
+        hours = row["hours"]
+        bar = hbar(hours or 0, top, width, row["target_hours"])
+        if hours is None:
+            color = "dim"
+        else:
+            color = "green" if hours >= row["target_hours"] else "red"
+        table.add_row(
+            f"{row['date']:%a %d}",
+            "" if hours is None else format_time(pd.Timedelta(hours=hours)),
+            format_time(pd.Timedelta(hours=row["target_hours"])),
+            Text(bar, style=color),
+        )
+    emit(table, rows, output)
+
+
+def invoice_lines(sessions) -> list[dict]:
+    """Hours and notes of each day, oldest first."""
+    days = {}
+    for session in sorted(sessions, key=lambda s: s['start']):
+        day = days.setdefault(
+            session['start'].date(), {"duration": pd.Timedelta(0), "notes": []}
+        )
+        day["duration"] += session['duration']
+        note = PROJECT_PATTERN.sub("", session['notes']).strip()
+        if note and note not in day["notes"]:
+            day["notes"].append(note)
+    return [
+        {"date": date, "hours": round(to_hours(day["duration"]), 2),
+         "notes": "; ".join(day["notes"])}
+        for date, day in days.items()
+    ]
+
+
+def invoice_markdown(title, lines, rate, currency, adjustments=()) -> str:
+    total = sum(line["hours"] for line in lines)
+    out = [f"# {title}", ""]
+    out.append("| Date | Description | Hours | Amount |")
+    out.append("| ---- | ----------- | ----: | -----: |")
+    for line in lines:
+        notes = line["notes"].replace("|", "\\|").replace("\n", " ")
+        amount = line["hours"] * rate
+        out.append(
+            f"| {line['date']} | {notes} | {line['hours']:.2f} | "
+            f"{amount:,.2f} {currency} |"
+        )
+    out.append("")
+    out.append(f"**Total hours:** {total:.2f}  ")
+    out.append(f"**Rate:** {rate:,.2f} {currency}/h  ")
+    out.append(f"**Amount:** {total * rate:,.2f} {currency}")
+    if adjustments:
+        out.append("")
+        out.append("_Adjusted: " + "; ".join(adjustments) + "._")
+    return "\n".join(out) + "\n"
+
+
+def invoice_html(title, lines, rate, currency, adjustments=()) -> str:
+    total = sum(line["hours"] for line in lines)
+    note = (
+        "<p><small>Adjusted: " + html.escape("; ".join(adjustments))
+        + ".</small></p>\n"
+    ) if adjustments else ""
+    cells = "".join(
+        f"<tr><td>{line['date']}</td><td>{html.escape(line['notes'])}</td>"
+        f"<td class=num>{line['hours']:.2f}</td>"
+        f"<td class=num>{line['hours'] * rate:,.2f} {currency}</td></tr>\n"
+        for line in lines
+    )
+    return (
+        "<!DOCTYPE html>\n<html><head><meta charset=utf-8>"
+        f"<title>{html.escape(title)}</title>"
+        "<style>body{font-family:sans-serif}table{border-collapse:collapse}"
+        "td,th{border:1px solid #999;padding:4px 8px}.num{text-align:right}"
+        "</style></head><body>\n"
+        f"<h1>{html.escape(title)}</h1>\n<table>\n"
+        "<tr><th>Date</th><th>Description</th><th>Hours</th><th>Amount</th></tr>\n"
+        f"{cells}</table>\n"
+        f"<p><b>Total hours:</b> {total:.2f}<br>"
+        f"<b>Rate:</b> {rate:,.2f} {currency}/h<br>"
+        f"<b>Amount:</b> {total * rate:,.2f} {currency}</p>\n"
+        f"{note}</body></html>\n"
+    )
+
+
+@app.command()
+def invoice(
+    project: str = typer.Argument(
+        ..., help="Project to invoice.", autocompletion=complete_projects
+    ),
+    from_: str = typer.Option(
+        None, "--from", help="First day, the 1st of last month by default."
+    ),
+    to: str = typer.Option(None, help="Last day, the end of last month by default."),
+    rate: float = typer.Option(None, help="Hourly rate, see [invoice]."),
+    currency: str = typer.Option(None, help="Currency, see [invoice]."),
+    output: str = typer.Option(
+        None, "--output", "-o", help="Write to this file, .md, .txt or .html."
+    ),
+):
+    """
+    Invoice the sessions of a project, one line per day.
+    """
+    t = Takt()
+    config = t.config.get("invoice", {})
+    rate = rate if rate is not None else config.get("rate", t.rate(project))
+    if rate is None:
+        raise InvalidArgsError("Set the hourly rate with --rate or in [rates].")
+    project_currency = t.config.get("projects", {}).get(project, {}).get("currency")
+    currency = currency or config.get("currency", project_currency or t.currency)
+    last_month = clock.now().normalize().replace(day=1) - pd.offsets.MonthBegin(1)
+    start = parse_timestamp(from_) if from_ else last_month
+    end = parse_timestamp(to) if to else last_month + pd.offsets.MonthEnd(0)
+    end = end.normalize() + pd.Timedelta(days=1)
+    sessions = [
+        s for s in t.sessions(project) if start <= s['start'] < end
+    ]
+    if not sessions:
+        raise TaktError(f"No sessions of +{project} in the period.")
+    lines = invoice_lines(sessions)
+    adjustments = [t.overlap_repair] if t.overlap_repair else []
+    raw = sum(to_hours(s['duration']) for s in sessions)
+    billed = sum(line["hours"] for line in lines)
+    if round(raw, 2) != round(billed, 2):
+        adjustments.append(
+            f"hours rounded to hundredths by day, {raw:.2f} tracked, "
+            f"{billed:.2f} billed"
+        )
+    period = f"{start:%Y-%m-%d} to {end - pd.Timedelta(days=1):%Y-%m-%d}"
+    title = config.get("title", "Invoice") + f" +{project}, {period}"
+    ext = Path(output).suffix.lower() if output else ".md"
+    if ext in (".html", ".htm"):
+        text = invoice_html(title, lines, float(rate), currency, adjustments)
+    elif ext in (".md", ".txt", ""):
+        text = invoice_markdown(title, lines, float(rate), currency, adjustments)
+    else:
+        raise InvalidArgsError(
+            f"Can't write an invoice as {ext}, use .md, .txt or .html."
+        )
+    if output is None:
+        sys.stdout.write(text)
+    else:
+        write_atomic(output, text)
+        t.print_console(f"Invoice written to {output}", style="green")
+
+
+@app.command()
+def report(
+    top_days: int = typer.Option(5, min=1, help="Days to list at each end."),
+    from_: str = typer.Option(None, "--from", help="First day of the range."),
+    to: str = typer.Option(None, help="Last day of the range."),
+    output: str = OUTPUT_OPTION,
+):
+    """
+    Longest and shortest tracked days of a range.
+    """
+    t = Takt()
+    days = t.aggregate(period='daily')
+    start = parse_timestamp(from_).date() if from_ else None
+    end = parse_timestamp(to).date() if to else None
+    days = [
+        d for d in days
+        if (start is None or pd.Timestamp(d['group']).date() >= start)
+        and (end is None or pd.Timestamp(d['group']).date() <= end)
+    ]
+    if not days:
+        raise TaktError("No tracked days in the range.")
+    days.sort(key=lambda d: d['duration'], reverse=True)
+    longest = days[:top_days]
+    shortest = days[::-1][:top_days]
+    tables, rows = [], []
+    for title, selection in (("Longest days", longest), ("Shortest days", shortest)):
+        table = Table(title=title, show_header=True, header_style="bold magenta")
+        table.add_column("Date", style="dim")
+        table.add_column("Hours", style="dim")
+        table.add_column("Notes", style="dim", max_width=NOTES_WIDTH)
+        for day in selection:
+            table.add_row(
+                day['group'], format_time(day['duration']), format_notes(day['notes'])
+            )
+            rows.append({"list": title.split()[0].lower(), **summary_to_json(day)})
+        tables.append(table)
+    emit(Group(*tables), rows, output)
+
+
+@app.command()
+def pairs(
+    from_: str = typer.Option(None, "--from", help="First day of the range."),
+    to: str = typer.Option(None, help="Last day of the range."),
+    output: str = OUTPUT_OPTION,
+):
+    """
+    Hours paired with each co-worker, see `check --with`.
+    """
+    t = Takt()
+    start = parse_timestamp(from_).date() if from_ else None
+    end = parse_timestamp(to).date() if to else None
+    sessions = [
+        s for s in t.sessions()
+        if (start is None or (s['start'] - t.day_start).date() >= start)
+        and (end is None or (s['start'] - t.day_start).date() <= end)
+    ]
+    total = sum((s['duration'] for s in sessions), pd.Timedelta(0))
+    people = {}
+    for session in sessions:
+        for name in session['coworkers']:
+            person = people.setdefault(name, [pd.Timedelta(0), 0])
+            person[0] += session['duration']
+            person[1] += 1
+    if not people:
+        raise TaktError("No paired sessions in the range, see `check --with`.")
+    table = Table(show_header=True, header_style="bold magenta")
+    for column in ("Person", "Hours", "Sessions", "Share"):
+        table.add_column(column, style="dim")
+    rows = []
+    for name, (duration, count) in sorted(
+        people.items(), key=lambda item: item[1][0], reverse=True
+    ):
+        share = duration / total if total else 0.0
+        table.add_row(name, format_time(duration), str(count), f"{share:.0%}")
+        rows.append({
+            "person": name,
+            "hours": to_hours(duration),
+            "sessions": count,
+            "share": round(share, 4),
+        })
+    emit(table, rows, output)
+
+
+def issue_sessions(t, from_, to) -> list[tuple[str, dict, pd.Timedelta]]:
+    """(issue, session, share) of the sessions mentioning issues in a range.
+
+    A session mentioning several issues is shared evenly between them.
+    """
+    start = parse_timestamp(from_).date() if from_ else None
+    end = parse_timestamp(to).date() if to else None
+    out = []
+    for session in t.sessions():
+        day = (session['start'] - t.day_start).date()
+        if (start is not None and day < start) or (end is not None and day > end):
+            continue
+        keys = issues_of(session['notes'])
+        out += [(key, session, session['duration'] / len(keys)) for key in keys]
+    return out
+
+
+@app.command()
+def issues(
+    from_: str = typer.Option(None, "--from", help="First day of the range."),
+    to: str = typer.Option(None, help="Last day of the range."),
+    output: str = OUTPUT_OPTION,
+):
+    """
+    Hours spent on each issue mentioned in the notes, e.g. PROJ-123 or #456.
+    """
+    t = Takt()
+    found = {}
+    for key, session, share in issue_sessions(t, from_, to):
+        issue = found.setdefault(key, [pd.Timedelta(0), 0, session['start']])
+        issue[0] += share
+        issue[1] += 1
+        issue[2] = max(issue[2], session['start'])
+    if not found:
+        raise TaktError("No issues mentioned in the notes of the range.")
+    table = Table(show_header=True, header_style="bold magenta")
+    for column in ("Issue", "Hours", "Sessions", "Last worked"):
+        table.add_column(column, style="dim")
+    rows = []
+    for key, (duration, count, last) in sorted(
+        found.items(), key=lambda item: item[1][0], reverse=True
+    ):
+        table.add_row(key, format_time(duration), str(count), f"{last:%Y-%m-%d}")
+        rows.append({
+            "issue": key,
+            "hours": to_hours(duration),
+            "sessions": count,
+            "last": last.isoformat(),
+        })
+    emit(table, rows, output)
+
+
+@app.command()
+def stats(
+    from_: str = typer.Option(None, "--from", help="First day of the range."),
+    to: str = typer.Option(None, help="Last day of the range."),
+    project: str = typer.Option(
+        None, help="Only the sessions of this project.",
+        autocompletion=complete_projects,
+    ),
+    output: str = OUTPUT_OPTION,
+):
+    """
+    Averages, longest streak, breaks and busiest weekday of a range.
+    """
+    t = Takt()
+    start = parse_timestamp(from_).date() if from_ else None
+    end = parse_timestamp(to).date() if to else None
+    sessions = [
+        s for s in t.sessions(project)
+        if (start is None or (s['start'] - t.day_start).date() >= start)
+        and (end is None or (s['start'] - t.day_start).date() <= end)
+    ]
+    if not sessions:
+        raise TaktError("No sessions in the range.")
+    info = session_stats(sessions, t.day_start)
+    lines = [
+        ("Sessions", str(info["sessions"])),
+        ("Days tracked", str(info["days"])),
+        ("Total hours", format_time(info["total"])),
+        ("Average start", format_time(info["average_start"])),
+        ("Average end", format_time(info["average_end"])),
+        ("Average session", format_time(info["average_session"])),
+        ("Average day", format_time(info["average_day"])),
+        (
+            "Longest streak",
+            f"{plural(info['longest_streak'], 'day')}, "
+            f"{info['streak_from']} to {info['streak_to']}",
+        ),
+        ("Break time", format_time(info["breaks"])),
+        (
+            "Busiest weekday",
+            f"{info['busiest_weekday']}, "
+            f"{format_time(info['busiest_weekday_total'])} hours",
+        ),
+    ]
+    table = Table(show_header=False, box=None)
+    table.add_column("Statistic", style="bold magenta")
+    table.add_column("Value")
+    for name, value in lines:
+        table.add_row(name, value)
+    row = {}
+    for key, value in info.items():
+        if isinstance(value, pd.Timedelta):
+            value = round(to_hours(value), 2)
+        elif not isinstance(value, int):
+            value = str(value)
+        row[key] = value
+    emit(table, [row], output)
+
+
+@app.command()
+def off(
+    date: str = typer.Argument(..., help="Day off."),
+    to: str = typer.Option(
+        None, help="Last day off of a range, weekends skipped."
+    ),
+    kind: str = typer.Option("vacation", help="vacation, sick or holiday."),
+    hours: str = typer.Option(
+        None, help="Time credited per day, the daily target by default."
+    ),
+    half: str = typer.Option(
+        None, help="Only the morning or afternoon off: am or pm."
+    ),
+    notes: str = "",
+):
+    """
+    Record vacation, sick leave or public holidays.
+    """
+    if kind not in LEAVE_TYPES:
+        raise InvalidArgsError(
+            f"Leave {kind} not supported, use {', '.join(LEAVE_TYPES)}."
+        )
+    if half is not None and half not in LEAVE_HALVES:
+        raise InvalidArgsError(
+            f"Half {half} not supported, use {', '.join(LEAVE_HALVES)}."
+        )
+    t = Takt()
+    if hours is None:
+        hours = t.report_options().get("target", DAILY_TARGET)
+        if half is not None:
+            # half days off credit half the target
+            hours = format_short(int(parse_duration(hours).total_seconds()) // 2)
+    parse_duration(hours)
+    first = parse_timestamp(date).normalize()
+    last = parse_timestamp(to).normalize() if to else first
+    if last < first:
+        raise InvalidArgsError("--to can't be before the first day.")
+    days = [
+        day for day in pd.date_range(first, last)
+        if day == first or day.dayofweek < 5
+    ]
+    t.pull()
+    known = leave_halves(t.all_rows())
+    wanted = {half} if half else set(LEAVE_HALVES)
+    for day in days:
+        if known.get(day.date(), set()) & wanted:
+            t.print_console(f"{day:%Y-%m-%d} is already off, skipped.", style="yellow")
+            continue
+        meta = format_meta({"leave": kind, "hours": hours, "half": half})
+        t.insert_row(day, LEAVE, notes, meta)
+        label = f"{kind} {half}" if half else kind
+        t.print_console(f"{day:%Y-%m-%d} off ({label}, {hours})", style="green")
+    t.push(f"takt: {kind} {first:%Y-%m-%d}" + (f" to {last:%Y-%m-%d}" if to else ""))
+
+
+@app.command()
+def balance(
+    period: str = typer.Option(
+        "mtd", help="Group by daily, wtd, mtd, quarter, half or ytd."
+    ),
+    since: str = typer.Option(None, help="First day, the first record by default."),
+    output: str = OUTPUT_OPTION,
+):
+    """
+    Worked time and leave against the daily target.
+    """
+    t = Takt()
+    target = parse_duration(t.report_options().get("target", DAILY_TARGET))
+    totals = t.daily_totals()
+    leave = t.leave()
+    today = clock.now().normalize()
+    days = list(totals) + list(leave)
+    if not days:
+        raise TaktError(f"No records found in {t.filename}.")
+    first = parse_timestamp(since).normalize() if since else pd.Timestamp(min(days))
+    week_start = t.config.get("week_start", DEFAULT_WEEK_START)
+    group = Aggregator(period, verbose=False, week_start=week_start).time_agg
+    holidays = t.holidays(first, today)
+
+    groups = {}
+    for day in pd.date_range(first, today):
+        worked = totals.get(day.date(), pd.Timedelta(0))
+        credited = leave.get(day.date(), ("", pd.Timedelta(0)))[1]
+        workday = day.dayofweek < 5 and day.date() not in holidays
+        expected = target if workday else pd.Timedelta(0)
+        row = groups.setdefault(group(day), [pd.Timedelta(0)] * 3)
+        row[0] += worked
+        row[1] += credited
+        row[2] += expected
+
+    table = Table(show_header=True, header_style="bold magenta")
+    for column in ("Period", "Worked", "Leave", "Expected", "Balance", "Total"):
+        table.add_column(column, style="dim")
+    rows = []
+    running = pd.Timedelta(0)
+    for name, (worked, credited, expected) in groups.items():
+        diff = worked + credited - expected
+        running += diff
+        table.add_row(
+            name, format_time(worked), format_time(credited),
+            format_time(expected), format_signed(diff), format_signed(running),
+            style="red" if diff < pd.Timedelta(0) else None,
+        )
+        rows.append({
+            "period": name,
+            "worked_hours": to_hours(worked),
+            "leave_hours": to_hours(credited),
+            "expected_hours": to_hours(expected),
+            "balance_hours": to_hours(diff),
+        })
+    emit(table, rows, output)
+
+
+@app.command()
+def cal(
+    month: str = typer.Argument(None, help="Month as YYYY-MM, the current one by default."),
+    week_start: str = typer.Option(None, help="First day of the week."),
+    output: str = OUTPUT_OPTION,
+):
+    """
+    Calendar of a month with the hours of each day.
+    """
+    t = Takt()
+    today = clock.now().normalize()
+    if month is None:
+        first = today.replace(day=1)
+    elif re.fullmatch(r"\d{4}-\d{2}", month):
+        first = pd.Timestamp(f"{month}-01")
+    else:
+        raise InvalidArgsError(f"Invalid month {month!r}, use e.g. 2024-03.")
+    last = first + pd.offsets.MonthEnd(0)
+    week_start = week_start or t.config.get("week_start", DEFAULT_WEEK_START)
+    if week_start not in WEEK_STARTS:
+        raise InvalidArgsError(
+            f"Week start {week_start} not supported, use sunday or monday."
+        )
+    target = parse_duration(t.report_options().get("target", DAILY_TARGET))
+    totals = t.daily_totals()
+    leave = t.leave()
+    holidays = t.holidays(first, last)
+
+    names = ["Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"]
+    if week_start == "monday":
+        names = names[1:] + names[:1]
+    table = Table(
+        title=f"{first:%B %Y}", show_header=True, header_style="bold magenta",
+        show_lines=True,
+    )
+    for name in names:
+        table.add_column(name, justify="right", style="dim" if name in ("Sat", "Sun") else None)
+    offset = (first.dayofweek + (1 if week_start == "sunday" else 0)) % 7
+    week, rows = [""] * offset, []
+    total = pd.Timedelta(0)
+    for day in pd.date_range(first, last):
+        duration = totals.get(day.date(), pd.Timedelta(0))
+        total += duration
+        weekend = day.dayofweek >= 5
+        hours = format_time(duration) if duration else ""
+        cell = f"[bold]{day.day}[/]\n{hours}"
+        if day.date() in leave:
+            cell = f"[bold]{day.day}[/]\n[cyan]{leave[day.date()][0]}[/]"
+            # half days off are worked too
+            if hours:
+                cell += f"\n{hours}"
+        elif day.date() in holidays:
+            cell = f"[bold]{day.day}[/]\n[cyan]{hours or 'holiday'}[/]"
+        # workdays under the target, up to today
+        elif not weekend and day <= today and duration < target:
+            cell = f"[bold]{day.day}[/]\n[red]{hours or '--:--'}[/]"
+        week.append(cell)
+        rows.append({
+            "date": day.date(),
+            "hours": to_hours(duration),
+            "leave": leave.get(day.date(), ("",))[0],
+            "holiday": holidays.get(day.date(), ""),
+        })
+        if len(week) == 7:
+            table.add_row(*week)
+            week = []
+    if week:
+        table.add_row(*week, *[""] * (7 - len(week)))
+    table.caption = f"{format_time(total)} hours, target {format_time(target)} a day"
+    emit(table, rows, output)
+
+
+@app.command()
+def compliance(
+    week_start: str = typer.Option(None, help="First day of the week."),
+    all_weeks: bool = typer.Option(
+        False, "--all", help="List every week, not only the violations."
+    ),
+    output: str = OUTPUT_OPTION,
+):
+    """
+    Check the tracked time against working time rules.
+    """
+    t = Takt()
+    rules = t.compliance_rules()
+    week_start = week_start or t.config.get("week_start", DEFAULT_WEEK_START)
+    if week_start not in WEEK_STARTS:
+        raise InvalidArgsError(
+            f"Week start {week_start} not supported, use sunday or monday."
+        )
+    sessions = t.sessions()
+    rows = []
+
+    weeks_table = Table(
+        title=f"Weekly hours, max {rules['max_weekly_hours']:g}h averaged over "
+        f"{rules['average_weeks']} weeks",
+        show_header=True,
+        header_style="bold magenta",
+    )
+    weeks_table.add_column("Week", style="dim")
+    weeks_table.add_column("Hours", style="dim")
+    weeks_table.add_column("Average", style="dim")
+    for week in weekly_averages(sessions, week_start, rules["average_weeks"]):
+        over = week["average"] > rules["max_weekly_hours"]
+        if not (over or all_weeks):
+            continue
+        style = "red" if over else None
+        weeks_table.add_row(
+            week["week"], f"{week['hours']:.2f}", f"{week['average']:.2f}",
+            style=style,
+        )
+        rows.append({"rule": "weekly_hours", **week, "violation": over})
+
+    rest_table = Table(
+        title="Daily rest, min "
+        f"{format_short(int(rules['min_daily_rest'].total_seconds()))}",
+        show_header=True,
+        header_style="bold magenta",
+    )
+    rest_table.add_column("Check out", style="dim")
+    rest_table.add_column("Check in", style="dim")
+    rest_table.add_column("Rest", style="dim")
+    for rest in rest_periods(sessions, t.day_start):
+        short = rest["rest"] < rules["min_daily_rest"]
+        if not (short or all_weeks):
+            continue
+        rest_table.add_row(
+            f"{rest['out']:%Y-%m-%d %H:%M}",
+            f"{rest['in']:%Y-%m-%d %H:%M}",
+            format_short(int(rest["rest"].total_seconds())),
+            style="red" if short else None,
+        )
+        rows.append({
+            "rule": "daily_rest",
+            "out": rest["out"],
+            "in": rest["in"],
+            "hours": to_hours(rest["rest"]),
+            "violation": short,
+        })
+
+    if not rows and output is None:
+        t.print_console("No violations found.", style="green")
+        return
+    emit(Group(weeks_table, rest_table), rows, output)
+
+
+@app.command("rename-project")
+def rename_project_cmd(
+    old: str = typer.Argument(
+        ..., help="Current project name.", autocompletion=complete_projects
+    ),
+    new: str = typer.Argument(..., help="New project name."),
+    dry_run: bool = typer.Option(False, help="Only show what would change."),
+):
+    """
+    Rename a project in every record and in the config.
+    """
+    t = Takt()
+    t.pull()
+    fm = t.file_manager
+    config_path = Path(CONFIG_FILE)
+    config_text = config_path.read_text() if config_path.exists() else ""
+    config_text, n_config = rename_config_key(config_text, old, new)
+    try:
+        tomllib.loads(config_text)
+    except tomllib.TOMLDecodeError as e:
+        raise ParseError(f"{CONFIG_FILE} would not be valid TOML: {e}")
+    n_records = 0
+    changed = []
+    with fm.lock():
+        for manager in [fm, *fm.archive_managers()]:
+            records = manager.load()
+            n = 0
+            for record in records:
+                notes = rename_project(record[NOTES], old, new)
+                if notes != record[NOTES]:
+                    record[NOTES] = notes
+                    n += 1
+            if n:
+                changed.append((manager, records))
+                n_records += n
+        t.print_console(
+            f"+{old} -> +{new}: {n_records} records, {n_config} config keys."
+        )
+        if dry_run or not (n_records or n_config):
+            return
+        for manager, records in changed:
+            t.print_console(f"Backup written to {manager.backup()}")
+            manager.save(records, backup=False)
+    if n_config:
+        write_atomic(str(config_path), config_text)
+    if changed:
+        t.push(
+            f"takt: rename project {old} to {new}",
+            [manager.filename for manager, _ in changed],
+        )
+
+
+@app.command()
+def sync():
+    """
+    Pull and push the records, resolving conflicts interactively.
+    """
+    t = Takt()
+    if t.git is None:
+        raise TaktError("Sync is disabled, set `sync = true` in the config.")
+    t.pull()
+    t.push("takt: sync")
+    t.print_console(f"Records in sync with {t.git.root}", style="green")
+
+
+@app.command()
+def review(
+    longer_than: str = typer.Option(
+        None, help="Flag sessions longer than this, 14h by default."
+    ),
+    output: str = OUTPUT_OPTION,
+):
+    """
+    List the sessions too long to be true, e.g. a forgotten check out.
+    """
+    t = Takt()
+    threshold = parse_duration(longer_than) if longer_than else t.suspicious
+    every = t.sessions()
+    sessions = [s for s in every if s['duration'] > threshold]
+    last = t.last_check()
+    # the open session ends now, checking out is the fix
+    open_session = (
+        every[-1] if last is not None and last[KIND] in CHECKED_IN_KINDS else None
+    )
+    if not sessions:
+        t.print_console(
+            "No sessions longer than "
+            f"{format_short(int(threshold.total_seconds()))}.",
+            style="green",
+        )
+        return
+    table = Table(show_header=True, header_style="bold magenta")
+    for column in ("In", "Out", "Hours", "Notes", "Fix with"):
+        table.add_column(column, style="dim")
+    rows = []
+    for session in sessions:
+        if session is open_session:
+            end, fix = "now", "takt check"
+        else:
+            end = f"{session['end']:%Y-%m-%d %H:%M}"
+            fix = f"takt edit --at '{session['end']}'"
+        table.add_row(
+            f"{session['start']:%Y-%m-%d %H:%M}",
+            end,
+            format_time(session['duration']),
+            session['notes'],
+            fix,
+        )
+        rows.append({
+            "start": session['start'].isoformat(),
+            "end": session['end'].isoformat(),
+            "hours": to_hours(session['duration']),
+            "notes": session['notes'],
+            "inferred": bool(session['inferred']),
+        })
+    emit(table, rows, output)
+
+
+@app.command()
+def doctor(
+    fix: str = typer.Option(
+        None, help="Repair the overlaps: merge or truncate them."
+    ),
+    output: str = OUTPUT_OPTION,
+):
+    """
+    List the lines of overlapping sessions in the records file.
+    """
+    if fix is not None and fix not in OVERLAP_FIXES:
+        raise InvalidArgsError(
+            f"Fix {fix} not supported, use {', '.join(OVERLAP_FIXES)}."
+        )
+    t = Takt()
+    if fix is not None:
+        t.pull()
+    with t.file_manager.lock():
+        numbered = list(t.file_manager.iter_records(lines=True))
+        lines = {id(record): line for line, record in numbered}
+        records = sorted(
+            (record for _, record in numbered),
+            key=lambda r: r[TIMESTAMP], reverse=True,
+        )
+        overlaps = find_overlaps(records)
+        if fix is not None and overlaps:
+            t.file_manager.save(repair_overlaps(records, overlaps, fix))
+    if not overlaps:
+        t.print_console(f"No overlapping sessions in {t.filename}.", style="green")
+        return
+    table = Table(show_header=True, header_style="bold magenta")
+    for column in ("Lines", "From", "To", "Notes"):
+        table.add_column(column, style="dim")
+    rows = []
+    for overlap in overlaps:
+        group = [
+            overlap["first"], *overlap["ins"], *overlap["outs"], overlap["last"]
+        ]
+        row = {
+            "lines": sorted(lines[id(r)] for r in group),
+            "from": overlap["first"][TIMESTAMP],
+            "to": overlap["last"][TIMESTAMP],
+            "notes": [r[NOTES] for r in (overlap["first"], *overlap["ins"])],
+        }
+        table.add_row(
+            ", ".join(str(line) for line in row["lines"]),
+            f"{row['from']:%Y-%m-%d %H:%M}",
+            f"{row['to']:%Y-%m-%d %H:%M}",
+            "; ".join(note for note in row["notes"] if note),
+        )
+        rows.append(row)
+    emit(table, rows, output)
+    if fix is None:
+        return
+    t.print_console(f"Repaired {len(overlaps)} overlaps ({fix}).", style="green")
+    t.push(f"takt: {fix} {len(overlaps)} overlapping sessions")
+
+
+@app.command("sort")
+def sort_cmd():
+    """
+    Sort the records file newest first.
+    """
+    t = Takt()
+    t.pull()
+    if not t.file_manager.sort():
+        t.print_console("Records are already sorted.")
+        return
+    t.print_console(f"Sorted {t.filename}", style="green")
+    t.push("takt: sort records")
+
+
+@app.command()
+def archive(
+    before: str = typer.Option(
+        None, help="Archive records older than this date, default Jan 1st."
+    ),
+):
+    """
+    Move old records to yearly archive files.
+    """
+    t = Takt()
+    now = clock.now()
+    cutoff = parse_timestamp(before) if before else pd.Timestamp(now.year, 1, 1)
+    archived = t.file_manager.archive(cutoff)
+    if not archived:
+        t.print_console(f"No records before {cutoff:%Y-%m-%d}.")
+        return
+    paths = [t.filename]
+    for year, n in sorted(archived.items()):
+        path = t.file_manager.archive_path(year)
+        paths.append(path)
+        t.print_console(f"Archived {n} records to {path}", style="green")
+    t.push(f"takt: archive records before {cutoff:%Y-%m-%d}", paths)
+
+
+@app.command()
+def restore(
+    backup: str = typer.Argument(
+        None, help="Backup file or its number in the list, omit it to list them."
+    ),
+):
+    """
+    List the backups of the records file or restore one.
+    """
+    t = Takt()
+    backups = t.file_manager.backups()
+    if backup is None:
+        if not backups:
+            t.print_console(f"No backups of {t.filename}.")
+        for i, path in enumerate(backups, 1):
+            t.print_console(f"{i:>3} {path}")
+        return
+    if backup.isdigit():
+        if not 0 < int(backup) <= len(backups):
+            raise InvalidArgsError(f"There is no backup number {backup}.")
+        backup = str(backups[int(backup) - 1])
+    if not Path(backup).exists():
+        raise FileMissingError(f"Backup {backup} does not exist.")
+    if backup.endswith(".zip"):
+        data = Path(backup).read_bytes()
+        restored = t.file_manager.restore_zip(data)
+        for path, n in restored.items():
+            t.print_console(f"Restored {n} records to {path}", style="green")
+        t.push(f"takt: restore {Path(backup).name}", list(restored))
+        return
+    t.file_manager.restore(backup)
+    t.print_console(f"Restored {t.filename} from {backup}", style="green")
+    t.push(f"takt: restore {Path(backup).name}")
+
+
+@app.command()
+def backup(
+    output: str = typer.Argument(..., help="Zip file to write."),
+    url: str = typer.Option(
+        None, help="Download the backup from this `takt serve` instead."
+    ),
+    token: str = typer.Option(
+        None, envvar="TAKT_TOKEN", help="Bearer token of the server."
+    ),
+):
+    """
+    Write a zip with the records, archives and journal.
+    """
+    if url is None:
+        data = Takt().file_manager.export_zip()
+    else:
+        headers = {"Authorization": f"Bearer {token}"} if token else {}
+        request = urllib.request.Request(f"{url.rstrip('/')}/backup", headers=headers)
+        try:
+            with urllib.request.urlopen(request) as response:
+                data = response.read()
+        except OSError as e:
+            raise TaktError(f"Backup from {url} failed: {e}")
+    write_atomic(output, data)
+    Takt.print_console(f"Backup written to {output}", style="green")
+
+
+@app.command()
+def undo():
+    """
+    Revert the last change to the records file.
+    """
+    t = Takt()
+    added, removed = t.file_manager.undo()
+    t.print_console(
+        f"Undone: {removed} records removed, {added} restored.", style="green"
+    )
+    t.push("takt: undo last change")
+
+
+def disk_usage(paths) -> int:
+    """Bytes used by the files in `paths`, directories included."""
+    total = 0
+    for path in map(Path, paths):
+        if path.is_dir():
+            total += sum(p.stat().st_size for p in path.rglob("*") if p.is_file())
+        elif path.exists():
+            total += path.stat().st_size
+    return total
+
+
+def format_bytes(size: int) -> str:
+    for unit in ("B", "KB", "MB"):
+        if size < 1024:
+            return f"{size:.0f} {unit}" if unit == "B" else f"{size:.1f} {unit}"
+        size /= 1024
+    return f"{size:.1f} GB"
+
+
+@app.command()
+def maintenance(
+    journal_days: int = typer.Option(
+        None, min=0, help="Fold journal changes older than this, 90 days."
+    ),
+):
+    """
+    Compact the journals and prune old backups, queued hook copies and indexes.
+    """
+    t = Takt()
+    config = t.config.get("maintenance", {})
+    days = journal_days if journal_days is not None else config.get(
+        "journal_days", JOURNAL_DAYS
+    )
+    cutoff = clock.now() - pd.Timedelta(days=days)
+    managers = [t.file_manager, *t.file_manager.archive_managers()]
+    journals = [m.journal.path for m in managers if m.journal is not None]
+    backup_dir = Path(t.file_manager.backup_config.get("dir", BACKUP_DIR))
+    usage = {
+        "Journals": journals,
+        "Backups": [backup_dir.expanduser()],
+        "Queue": [QUEUE_DIR],
+        "Index": [INDEX_DIR],
+    }
+    before = {name: disk_usage(paths) for name, paths in usage.items()}
+
+    folded = 0
+    with t.file_manager.lock():
+        for manager in managers:
+            if manager.journal is not None and manager.journal.exists():
+                folded += manager.journal.compact(cutoff)
+    t.file_manager.prune_backups()
+    # copies of hooks dropped or retried while the queue was edited by hand
+    with queue_lock():
+        queued = {entry.get("snapshot") for entry in load_queue()}
+        for path in Path(QUEUE_DIR).glob("*"):
+            # the lock held here and files being written by `write_atomic`
+            if path.suffix == ".lock" or path.name.startswith("."):
+                continue
+            if str(path) != QUEUE_FILE and str(path) not in queued:
+                path.unlink()
+    # indexes of records files moved or deleted
+    for path in Path(INDEX_DIR).glob("*.json"):
+        try:
+            indexed = json.loads(path.read_text())["file"]
+        except (OSError, ValueError, KeyError):
+            indexed = None
+        if indexed is None or not Path(indexed).exists():
+            path.unlink()
+
+    table = Table(show_header=True, header_style="bold magenta")
+    for column in ("Files", "Before", "After", "Reclaimed"):
+        table.add_column(column, style="dim")
+    rows = []
+    for name, paths in usage.items():
+        after = disk_usage(paths)
+        table.add_row(
+            name, format_bytes(before[name]), format_bytes(after),
+            format_bytes(before[name] - after),
+        )
+        rows.append({"files": name, "before": before[name], "after": after})
+    emit(table, rows)
+    t.print_console(
+        f"Folded {folded} journal events older than {days} days.", style="green"
+    )
+
+
+@app.command()
+def rebuild():
+    """
+    Rewrite the records file from its journal.
+    """
+    t = Takt()
+    n = t.file_manager.rebuild()
+    t.print_console(f"Rebuilt {t.filename} with {n} records.", style="green")
+    t.push("takt: rebuild records from the journal")
+
+
+@app.command()
+def init(
+    encrypted_repo: str = typer.Option(
+        None,
+        help="Encrypt the records file in its git repo: 'git-crypt' or 'age'.",
+    ),
+    recipient: str = typer.Option(None, help="age recipient to encrypt to."),
+    identity: str = typer.Option(
+        "~/.config/age/key.txt", help="age identity file to decrypt with."
+    ),
+):
+    """
+    Create the records file and optionally set up encryption in git.
+    """
+    t = Takt()
+    t.file_manager.exists(create=True)
+    t.print_console(f"Records file: {t.filename}", style="green")
+    if encrypted_repo is None:
+        return
+    git = GitSync(t.filename)
+    if encrypted_repo == "git-crypt":
+        git.encrypt_with_git_crypt()
+    elif encrypted_repo == "age":
+        if recipient is None:
+            raise InvalidArgsError("`--recipient` is required with age.")
+        git.encrypt_with_age(recipient, identity)
+    else:
+        raise InvalidArgsError(
+            f"Encryption {encrypted_repo} not supported, use git-crypt or age."
+        )
+    t.print_console(
+        f"Records file will be encrypted with {encrypted_repo} in {git.root}.",
+        style="green",
+    )
+
+
+@app.command("break")
+def break_(
+    words: list[str] = NOTE_WORDS_ARGUMENT,
+    notes: str = NOTE_OPTION,
+    edit_note: bool = EDIT_NOTE_OPTION,
+):
+    """
+    Start a break without checking out.
+    """
+    notes = read_note(words, notes, edit_note)
+    t = Takt()
+    t.pull()
+    last = t.last_check()
+    if last is None or last[KIND] not in ('in', BREAK_END):
+        raise TaktError("You must be checked in to take a break.")
+    timestamp = clock.now()
+    t.insert_row(timestamp, BREAK_START, notes)
+    t.print_console(
+        f"Break [bold magenta]START[/] at {timestamp}", style="green"
+    )
+    t.push(f"takt: {BREAK_START} at {timestamp:%Y-%m-%d %H:%M:%S}")
+    run_hook(t, "post_break", {TIMESTAMP: timestamp, KIND: BREAK_START, NOTES: notes})
+
+
+@app.command()
+def resume(
+    words: list[str] = NOTE_WORDS_ARGUMENT,
+    notes: str = NOTE_OPTION,
+    edit_note: bool = EDIT_NOTE_OPTION,
+):
+    """
+    Resume work after a break.
+    """
+    notes = read_note(words, notes, edit_note)
+    t = Takt()
+    t.pull()
+    last = t.last_check()
+    if last is None or last[KIND] != BREAK_START:
+        raise TaktError("You are not on a break.")
+    timestamp = clock.now()
+    t.insert_row(timestamp, BREAK_END, notes)
+    t.print_console(
+        f"Break [bold magenta]END[/] at {timestamp}", style="green"
+    )
+    t.push(f"takt: {BREAK_END} at {timestamp:%Y-%m-%d %H:%M:%S}")
+    run_hook(t, "post_resume", {TIMESTAMP: timestamp, KIND: BREAK_END, NOTES: notes})
+
+
+@app.command()
+def serve(
+    host: str = typer.Option(DEFAULT_HOST, help="Address to listen on."),
+    port: int = typer.Option(DEFAULT_PORT, help="Port to listen on."),
+    token: str = typer.Option(
+        None, envvar="TAKT_TOKEN", help="Require this bearer token."
+    ),
+    auth: str = typer.Option(
+        None, help="Authentication: token, basic, oidc or mtls."
+    ),
+    tls_cert: str = typer.Option(None, help="Serve HTTPS with this certificate."),
+    tls_key: str = typer.Option(None, help="Private key of the certificate."),
+    client_ca: str = typer.Option(
+        None, help="CA verifying client certificates, required by mtls."
+    ),
+    allow_restore: bool = typer.Option(
+        False, help="Accept backups on POST /restore."
+    ),
+    metrics_history: bool = typer.Option(
+        False, help="Serve cached hourly totals on /metrics/history."
+    ),
+):
+    """
+    Serve the records over a REST API.
+    """
+    config = load_config().get("serve", {})
+    auth = auth or config.get("auth") or ("token" if token else None)
+    tls = config.get("tls", {})
+    tls_cert = tls_cert or tls.get("cert")
+    tls_key = tls_key or tls.get("key")
+    client_ca = client_ca or tls.get("client_ca")
+    if auth is None and not is_loopback(host):
+        # records, exports and backups would be open to the network
+        raise InvalidArgsError(
+            f"Listening on {host} needs authentication.",
+            hint="Set `--token` or TAKT_TOKEN, choose `--auth`, or listen on "
+            f"{DEFAULT_HOST}.",
+        )
+    TaktHandler.authenticator = make_authenticator(auth, token, config)
+    TaktHandler.feed_secret = config.get("feed_secret")
+    TaktHandler.allow_restore = allow_restore or config.get("allow_restore", False)
+    if metrics_history or config.get("metrics_history", False):
+        TaktHandler.metrics = MetricsHistory(config.get("metrics_days", METRICS_DAYS))
+    if TaktHandler.allow_restore and auth is None:
+        raise InvalidArgsError("--allow-restore needs authentication.")
+    members = config.get("team", {}).get("members", {})
+    if members:
+        if len(members) < TEAM_MIN_MEMBERS:
+            raise InvalidArgsError(
+                f"[serve.team] needs at least {TEAM_MIN_MEMBERS} members."
+            )
+        TaktHandler.team = [os.path.expanduser(f) for f in members.values()]
+    if auth == "mtls" and not (client_ca and tls_cert):
+        raise InvalidArgsError("mtls needs `--tls-cert` and `--client-ca`.")
+    server = ThreadingHTTPServer((host, port), TaktHandler)
+    scheme = "http"
+    if tls_cert:
+        context = ssl.SSLContext(ssl.PROTOCOL_TLS_SERVER)
+        context.load_cert_chain(
+            os.path.expanduser(tls_cert),
+            tls_key and os.path.expanduser(tls_key),
+        )
+        if client_ca:
+            context.verify_mode = ssl.CERT_REQUIRED
+            context.load_verify_locations(os.path.expanduser(client_ca))
+        server.socket = context.wrap_socket(server.socket, server_side=True)
+        scheme = "https"
+    # on shutdown wait for the requests in flight, e.g. a check being written
+    server.daemon_threads = False
+
+    def stop(signum, frame):
+        TaktHandler.stopping.set()
+        # shutdown() waits for serve_forever, which runs in this thread
+        threading.Thread(target=server.shutdown).start()
+
+    signal.signal(signal.SIGTERM, stop)
+    Takt.print_console(f"Serving takt on {scheme}://{host}:{port}", style="green")
+    try:
+        server.serve_forever()
+    except KeyboardInterrupt:
+        pass
+    finally:
+        TaktHandler.stopping.set()
+        server.server_close()
+        Takt.print_console("Stopped.")
+
+
+@app.command("hash-password")
+def hash_password_cmd(
+    iterations: int = typer.Option(
+        PASSWORD_ITERATIONS, min=1, help="pbkdf2 rounds, fewer on slow machines."
+    ),
+):
+    """
+    Print the salted hash of a password for `[serve.basic.users]`.
+    """
+    password = typer.prompt("Password", hide_input=True, confirmation_prompt=True)
+    typer.echo(hash_password(password, iterations=iterations))
+
+
+@app.command("feed-url")
+def feed_url(
+    project: str = typer.Argument(
+        None, help="Project to share, every session by default.",
+        autocompletion=complete_projects,
+    ),
+    base_url: str = typer.Option(
+        f"http://{DEFAULT_HOST}:{DEFAULT_PORT}", help="Public URL of `takt serve`."
+    ),
+):
+    """
+    Print the signed feed URLs of a project to share with a client.
+
+    Without a project, the calendar of every session to subscribe to.
+    """
+    secret = load_config().get("serve", {}).get("feed_secret")
+    if not secret:
+        raise InvalidArgsError("Set `feed_secret` in [serve] to share feeds.")
+    if project is None:
+        signature = feed_signature(secret, ALL_SESSIONS)
+        typer.echo(f"{base_url.rstrip('/')}{CALENDAR_FEED}?sig={signature}")
+        return
+    signature = feed_signature(secret, project)
+    for ext in ("ics", "json"):
+        typer.echo(f"{base_url.rstrip('/')}{FEED_PREFIX}{project}.{ext}?sig={signature}")
+
+
+@app.command("sync-daemon")
+def sync_daemon(
+    url: str = typer.Argument(..., help="URL of a `takt serve` instance."),
+    token: str = typer.Option(
+        None, envvar="TAKT_TOKEN", help="Bearer token of the server."
+    ),
+):
+    """
+    Apply the records of a remote takt server as soon as they change.
+
+    Records added or removed on the server are added or removed here, the
+    ones only written here are kept.
+    """
+    t = Takt()
+    origin = urlparse(url).netloc
+    headers = {"Authorization": f"Bearer {token}"} if token else {}
+    request = urllib.request.Request(f"{url.rstrip('/')}/events", headers=headers)
+    # the remote records seen last, what is missing from the next were removed
+    digest = hashlib.sha256(url.encode()).hexdigest()[:16]
+    seen_path = Path(SYNC_DIR, f"{digest}.json")
+    seen = (
+        {tuple(r) for r in json.loads(seen_path.read_text())}
+        if seen_path.exists() else None
+    )
+    t.print_console(f"Following {url}", style="green")
+    while True:
+        try:
+            with urllib.request.urlopen(request) as stream:
+                for event, data in read_events(stream):
+                    if event != "records":
+                        continue
+                    remote = {}
+                    for r in json.loads(data):
+                        record = FileRow(
+                            pd.Timestamp(r[TIMESTAMP]),
+                            r[KIND],
+                            r[NOTES],
+                            format_meta({"origin": origin, **parse_meta(r.get(META))}),
+                        )
+                        remote[(r[TIMESTAMP], r[KIND], r[NOTES])] = record
+                    removed = [
+                        FileRow(pd.Timestamp(timestamp), kind, notes)
+                        for timestamp, kind, notes in (seen or set()) - remote.keys()
+                    ]
+                    added = [
+                        r for key, r in remote.items() if seen is None or key not in seen
+                    ]
+                    counts = t.file_manager.apply_remote(added, removed)
+                    seen = set(remote)
+                    os.makedirs(SYNC_DIR, exist_ok=True)
+                    write_atomic(seen_path, json.dumps(sorted(seen)) + "\n")
+                    if any(counts):
+                        t.print_console(
+                            f"Merged remote records: {counts[0]} added, "
+                            f"{counts[1]} removed."
+                        )
+        except KeyboardInterrupt:
+            return
+        except OSError as e:
+            t.print_console(
+                f"Connection to {url} lost ({e}), retrying in {SYNC_RETRY}s.",
+                style="yellow",
+            )
+        time.sleep(SYNC_RETRY)
+
+
+@app.command()
+def start(
+    task: str = typer.Argument(..., help="Name of the task."),
+    notes: str = "",
+):
+    """
+    Start a named task timer while checked in.
+    """
+    t = Takt()
+    t.pull()
+    records = t.file_manager.load()
+    last = last_check(records)
+    if last is None or last[KIND] not in CHECKED_IN_KINDS:
+        raise TaktError("You must be checked in to start a task, run `takt check`.")
+    if task in running_tasks(records):
+        raise TaktError(f"Task {task} is already running.")
+    if re.search(r"[;=]", task):
+        raise InvalidArgsError("Task names can't contain ';' or '='.")
+    timestamp = clock.now()
+    t.insert_row(timestamp, TASK_START, notes, format_meta({"task": task}))
+    t.print_console(
+        f"Task [bold magenta]{task}[/] started at {timestamp}", style="green"
+    )
+    t.push(f"takt: start {task} at {timestamp:%Y-%m-%d %H:%M:%S}")
+
+
+@app.command()
+def stop(
+    task: str = typer.Argument(
+        None, help="Task to stop, omit it to stop every running task."
+    ),
+):
+    """
+    Stop task timers.
+    """
+    t = Takt()
+    t.pull()
+    running = running_tasks(t.file_manager.load())
+    if task is not None and task not in running:
+        raise TaktError(f"Task {task} is not running.")
+    tasks = [task] if task is not None else list(running)
+    if not tasks:
+        raise TaktError("There are no running tasks.")
+    timestamp = clock.now()
+    for name in tasks:
+        t.insert_row(timestamp, TASK_STOP, "", format_meta({"task": name}))
+        elapsed = format_time(timestamp - running[name])
+        t.print_console(
+            f"Task [bold magenta]{name}[/] stopped after {elapsed}", style="green"
+        )
+    t.push(f"takt: stop {', '.join(tasks)} at {timestamp:%Y-%m-%d %H:%M:%S}")
+
+
+@app.command()
+def tasks(
+    since: str = typer.Option(None, help="Only timers started since this date."),
+    output: str = OUTPUT_OPTION,
+):
+    """
+    Time spent on each task.
+    """
+    t = Takt()
+    records = t.all_rows()
+    if since is not None:
+        cutoff = parse_timestamp(since)
+        records = [r for r in records if r[TIMESTAMP] >= cutoff]
+    now = clock.now()
+    running = running_tasks(records)
+    totals = task_durations(records, now)
+    table = Table(show_header=True, header_style="bold magenta")
+    table.add_column("Task", style="dim")
+    table.add_column("Hours", style="dim")
+    table.add_column("Timers", style="dim")
+    rows = []
+    for task, (duration, count) in sorted(
+        totals.items(), key=lambda item: item[1][0], reverse=True
+    ):
+        name = f"{task} (running)" if task in running else task
+        table.add_row(name, format_time(duration), str(count))
+        rows.append({"task": task, "hours": to_hours(duration), "timers": count})
+    emit(table, rows, output)
+
+
+@app.command()
+def progress(output: str = OUTPUT_OPTION):
+    """
+    Tracked time against the estimates of projects and tasks.
+    """
+    t = Takt()
+    estimates = t.progress()
+    if not estimates:
+        raise TaktError(
+            "No estimates found, add them to [estimates.projects] or "
+            "[estimates.tasks] in the config."
+        )
+    table = Table(show_header=True, header_style="bold magenta")
+    table.add_column("Name", style="dim")
+    table.add_column("Actual", style="dim")
+    table.add_column("Estimate", style="dim")
+    table.add_column("Done", style="dim")
+    table.add_column("Left", style="dim")
+    rows = []
+    for row in estimates:
+        name = f"+{row['name']}" if row["type"] == "project" else row["name"]
+        done = row["actual"] / row["estimate"] if row["estimate"] else 0.0
+        left = max(row["estimate"] - row["actual"], pd.Timedelta(0))
+        table.add_row(
+            name,
+            format_time(row["actual"]),
+            format_time(row["estimate"]),
+            f"{done:.0%}",
+            format_time(left),
+            style="red" if done > 1 else None,
+        )
+        rows.append({
+            "type": row["type"],
+            "name": row["name"],
+            "actual_hours": to_hours(row["actual"]),
+            "estimate_hours": to_hours(row["estimate"]),
+            "done": done,
+        })
+    emit(table, rows, output)
+
+
+UI_KEYS = "c check in/out  e edit note  d delete  j/k move  q quit"
+
+
+def ui_prompt(screen, text: str) -> str:
+    """Read a line on the bottom row of the screen."""
+    import curses
+
+    height, width = screen.getmaxyx()
+    screen.move(height - 1, 0)
+    screen.clrtoeol()
+    screen.addnstr(height - 1, 0, text, width - 1, curses.A_BOLD)
+    screen.refresh()
+    curses.echo()
+    curses.curs_set(1)
+    screen.timeout(-1)
+    try:
+        value = screen.getstr(height - 1, min(len(text), width - 2))
+    finally:
+        curses.noecho()
+        curses.curs_set(0)
+        screen.timeout(UI_REFRESH)
+    return value.decode(errors="replace").strip()
+
+
+def ui_draw(screen, t, records, today, selected, message):
+    """Draw the status, today's records and the week to date."""
+    import curses
+
+    screen.erase()
+    height, width = screen.getmaxyx()
+
+    def line(y, text, attr=curses.A_NORMAL):
+        if y < height - 1:
+            screen.addnstr(y, 0, text, width - 1, attr)
+
+    info = t.status(records)
+    line(0, f"takt  {format_status(info)}", curses.A_BOLD)
+    line(2, "Today", curses.A_UNDERLINE)
+    y = 3
+    for n, i in enumerate(today):
+        record = records[i]
+        text = f"{record[TIMESTAMP]:%H:%M}  {record[KIND]:<11} {record[NOTES]}"
+        line(y, text, curses.A_REVERSE if n == selected else curses.A_NORMAL)
+        y += 1
+    if not today:
+        line(y, "No records yet.")
+        y += 1
+
+    line(y + 1, "Last 7 days", curses.A_UNDERLINE)
+    totals = t.daily_totals(records)
+    now = clock.now().normalize()
+    days = [now - pd.Timedelta(days=n) for n in range(6, -1, -1)]
+    target = parse_duration(t.report_options().get("target", DAILY_TARGET))
+    longest = max([target] + [totals.get(d.date(), pd.Timedelta(0)) for d in days])
+    bar_width = max(width - 24, 10)
+    for n, day in enumerate(days):
+        duration = totals.get(day.date(), pd.Timedelta(0))
+        bar = "#" * int(bar_width * (duration / longest))
+        line(y + 2 + n, f"{day:%a %m-%d} {format_time(duration)} {bar}")
+
+    line(height - 2, message, curses.A_BOLD)
+    screen.addnstr(height - 1, 0, UI_KEYS, width - 1, curses.A_DIM)
+    screen.refresh()
+
+
+def file_stamp(filename) -> tuple | None:
+    """Size and mtime of a file, None if it doesn't exist."""
+    try:
+        stat = os.stat(filename)
+    except FileNotFoundError:
+        return None
+    return stat.st_size, stat.st_mtime_ns
+
+
+def run_ui(screen):
+    """Main loop of `takt ui`, redrawing every `UI_REFRESH` ms.
+
+    The records are read again only when the file changes, the totals of
+    the open session are recomputed on every redraw.
+    """
+    import curses
+
+    curses.curs_set(0)
+    screen.timeout(UI_REFRESH)
+    selected, message = 0, ""
+    t, stamp = None, None
+    while True:
+        if t is None or file_stamp(t.filename) != stamp:
+            t = Takt()
+            t.interactive = False
+            stamp = file_stamp(t.filename)
+            records = t.all_rows() if stamp is not None else []
+        day = clock.now().date()
+        today = [i for i, r in enumerate(records) if r[TIMESTAMP].date() == day]
+        selected = min(selected, max(len(today) - 1, 0))
+        ui_draw(screen, t, records, today, selected, message)
+        key = screen.getch()
+        if key == -1:
+            continue
+        message = ""
+        key = chr(key) if 0 <= key < 256 else key
+        try:
+            if key == "q":
+                return
+            elif key in ("j", curses.KEY_DOWN):
+                selected = min(selected + 1, max(len(today) - 1, 0))
+            elif key in ("k", curses.KEY_UP):
+                selected = max(selected - 1, 0)
+            elif key == "c":
+                kind, timestamp = t.check()
+                message = f"Check {kind.upper()} at {timestamp:%H:%M}"
+                run_hook(
+                    t, "post_check", {TIMESTAMP: timestamp, KIND: kind, NOTES: ""}
+                )
+                update_presence(t, kind, "")
+            elif key == "e" and today:
+                record = records[today[selected]]
+                notes = ui_prompt(screen, "Note: ")
+                t.pull()
+                t.file_manager.update(record, {NOTES: notes})
+                t.push(f"takt: edit record at {record[TIMESTAMP]:%Y-%m-%d %H:%M:%S}")
+                message = "Note updated"
+            elif key == "d" and today:
+                record = records[today[selected]]
+                question = f"Delete {record[TIMESTAMP]:%H:%M} {record[KIND]}? [y/N] "
+                if ui_prompt(screen, question).lower() == "y":
+                    t.pull()
+                    t.file_manager.remove([record])
+                    t.push(
+                        f"takt: remove record at {record[TIMESTAMP]:%Y-%m-%d %H:%M:%S}"
+                    )
+                    message = "Record deleted"
+        except TaktError as e:
+            message = f"Error: {e}"
+
+
+def fuzzy_score(query: str, text: str):
+    """Score of `query` as a subsequence of `text`, None if it isn't one.
+
+    Consecutive letters and letters starting a word score higher, so `rev`
+    ranks `+acme review` above `reserved venue`.
+    """
+    query, text = query.lower(), text.lower()
+    score, start, previous = 0, 0, -2
+    for char in query:
+        found = text.find(char, start)
+        if found == -1:
+            return None
+        score += 3 if found == previous + 1 else 1
+        if found == 0 or text[found - 1] in " +-_:":
+            score += 2
+        previous, start = found, found + 1
+    return score
+
+
+def fuzzy_filter(query: str, labels: list[str]) -> list[int]:
+    """Indexes of the `labels` matching `query`, best first."""
+    scores = [(fuzzy_score(query, label), i) for i, label in enumerate(labels)]
+    # sorted is stable, equal scores keep the newest first
+    return [
+        i for score, i in sorted(
+            (x for x in scores if x[0] is not None), key=lambda x: -x[0]
+        )
+    ]
+
+
+def run_picker(screen, labels, multiple=False) -> list[int]:
+    """Fuzzy picker over `labels`, return the chosen indexes, [] if cancelled.
+
+    Typing filters, arrows or ctrl-n/p move, tab marks several when
+    `multiple`, enter picks and esc cancels.
     """
-    t = Takt()
-    timestamp = pd.Timestamp.now()
-    last_kind = t.first_row()
-    # infer kind
-    if last_kind is None or last_kind[KIND] == 'out':
-        kind = 'in'
-    else:
-        kind = 'out'
-    t.insert_row(timestamp, kind, notes)
-    t.print_console(
-        f"Check [bold magenta]{kind.upper()}[/] at {timestamp}", style="green"
-    )
+    import curses
+
+    query, selected, marked = "", 0, set()
+    while True:
+        matches = fuzzy_filter(query, labels)
+        selected = min(selected, max(len(matches) - 1, 0))
+        height, width = screen.getmaxyx()
+        screen.erase()
+        screen.addnstr(0, 0, f"> {query}", width - 1, curses.A_BOLD)
+        screen.addnstr(
+            0, max(width - 16, len(query) + 3), f"{len(matches)}/{len(labels)}",
+            15, curses.A_DIM,
+        )
+        top = max(0, selected - (height - 3))
+        for row, i in enumerate(matches[top:top + height - 2], start=1):
+            prefix = "* " if i in marked else "  "
+            attr = curses.A_REVERSE if top + row - 1 == selected else 0
+            screen.addnstr(row, 0, prefix + labels[i], width - 1, attr)
+        screen.refresh()
+        key = screen.get_wch()
+        if key in ("\x1b", "\x03"):
+            return []
+        elif key in ("\n", "\r", curses.KEY_ENTER):
+            if marked:
+                return sorted(marked)
+            return [matches[selected]] if matches else []
+        elif key in (curses.KEY_UP, "\x10"):
+            selected = max(selected - 1, 0)
+        elif key in (curses.KEY_DOWN, "\x0e"):
+            selected = min(selected + 1, max(len(matches) - 1, 0))
+        elif key == "\t" and multiple and matches:
+            marked ^= {matches[selected]}
+            selected = min(selected + 1, len(matches) - 1)
+        elif key in (curses.KEY_BACKSPACE, "\x7f", "\b"):
+            query, selected = query[:-1], 0
+        elif isinstance(key, str) and key.isprintable():
+            query, selected = query + key, 0
+
+
+def pick_records(records, multiple=False) -> list[int]:
+    """Indexes of the recent `records` picked with `run_picker`."""
+    try:
+        import curses
+    except ImportError:
+        raise TaktError("The picker needs curses, which this Python lacks.")
+    if not sys.stdin.isatty():
+        raise InvalidArgsError("The picker needs a terminal.")
+    recent = records[:PICKER_RECORDS]
+    labels = [
+        f"{r[TIMESTAMP]:%Y-%m-%d %H:%M}  {r[KIND]:<11}  {r[NOTES]}" for r in recent
+    ]
+    return curses.wrapper(run_picker, labels, multiple)
+
+
+@app.command()
+def ui():
+    """
+    Full screen view of today and the week, with keys to check in or out and
+    to edit or delete today's records.
+    """
+    try:
+        import curses
+    except ImportError:
+        raise TaktError("`takt ui` needs curses, which this Python lacks.")
+    # warnings would be drawn over the screen
+    console.quiet = err_console.quiet = True
+    try:
+        curses.wrapper(run_ui)
+    finally:
+        console.quiet = err_console.quiet = False
 
 
 @app.command()
-def display():
+def display(
+    head: int = typer.Option(
+        None, "--head", "-n", min=1, help="Only the newest N records."
+    ),
+    tail: int = typer.Option(None, min=1, help="Only the oldest N records."),
+    from_: str = typer.Option(None, "--from", help="First day of the range."),
+    to: str = typer.Option(None, help="Last day of the range."),
+    output: str = OUTPUT_OPTION,
+):
     """
-    Show all records.
+    Show all records, newest first.
     """
+    if head is not None and tail is not None:
+        raise InvalidArgsError("Use either --head or --tail.")
     t = Takt()
-    data = t.all_rows()
+    if head is not None and from_ is None and to is None:
+        # only reads the top of the records file, archives are older
+        data = t.all_rows(nrows=head)
+        if len(data) < head:
+            data = t.all_rows()
+    else:
+        data = t.all_rows()
+    start = parse_timestamp(from_).date() if from_ else None
+    end = parse_timestamp(to).date() if to else None
+    data = [
+        r for r in data
+        if (start is None or r[TIMESTAMP].date() >= start)
+        and (end is None or r[TIMESTAMP].date() <= end)
+    ]
+    if head is not None:
+        data = data[:head]
+    if tail is not None:
+        data = data[-tail:]
+    if not data:
+        raise TaktError(f"No records found in {t.filename}.")
 
+    columns = COLUMNS + [c for c in EXTENDED_COLUMNS if any(r[c] for r in data)]
     table = Table(show_header=True, header_style="bold magenta")
-    for column in data[0].keys():
+    for column in columns:
         table.add_column(column, style="dim")
     for row in data:
-        timestamp, kind, notes = row.values()
-        timestamp = str(timestamp)
-        table.add_row(timestamp, kind, notes)
+        table.add_row(*(str(row[column]) for column in columns))
+
+    emit(table, [record_to_json(row) for row in data], output)
+
+
+@app.command()
+def export(
+    output: str = typer.Argument(..., help="File to write, .csv, .json, .txt or .html."),
+    records: bool = typer.Option(
+        False, "--records", help="Export the raw records instead of sessions."
+    ),
+    project: str = typer.Option(
+        None, help="Only the sessions of this project.",
+        autocompletion=complete_projects,
+    ),
+    sign: bool = typer.Option(False, help="Also write a detached signature."),
+    format: str = typer.Option(
+        None, "--format", "-f",
+        help="excel-csv for Excel, the extension of the file by default.",
+    ),
+):
+    """
+    Export the sessions, or records, for an audit.
+    """
+    if format is not None and format not in EXPORT_FORMATS:
+        raise InvalidArgsError(
+            f"Format {format} not supported, use {', '.join(EXPORT_FORMATS)}."
+        )
+    t = Takt()
+    if sign:
+        config = t.config.get("sign", {})
+        method, key = config.get("method", "minisign"), config.get("key")
+        if key is None:
+            raise InvalidArgsError("--sign needs `key` in the [sign] config.")
+    table = Table(show_header=True, header_style="bold magenta")
+    if records:
+        data = t.all_rows()
+        columns = COLUMNS + [c for c in EXTENDED_COLUMNS if any(r[c] for r in data)]
+        for column in columns:
+            table.add_column(column)
+        for row in data:
+            table.add_row(*(str(row[column]) for column in columns))
+        rows = [record_to_json(row) for row in data]
+    else:
+        sessions = t.sessions(project)
+        for column in ("Start", "End", "Hours", "Notes"):
+            table.add_column(column)
+        for session in sessions:
+            table.add_row(
+                f"{session['start']:%Y-%m-%d %H:%M}",
+                f"{session['end']:%Y-%m-%d %H:%M}",
+                format_time(session['duration']),
+                session['notes'],
+            )
+        rows = sessions_to_json(sessions)
+    if format == "excel-csv":
+        decimal = t.config.get("excel", {}).get("decimal", EXCEL_DECIMAL)
+        for row in rows:
+            for key, value in row.items():
+                # Excel recognizes timestamps without the T
+                if isinstance(value, str) and ISO_TIMESTAMP.fullmatch(value):
+                    row[key] = value.replace("T", " ")
+        write_atomic(output, excel_csv(rows, decimal))
+    else:
+        emit(table, rows, output)
+    t.print_console(f"Exported {len(rows)} rows to {output}", style="green")
+    if not records and t.overlap_repair:
+        t.print_console(f"Adjusted: {t.overlap_repair}.", style="yellow")
+    if sign:
+        signature = sign_file(output, method, key)
+        t.print_console(f"Signature written to {signature}", style="green")
+
+
+import_app = typer.Typer(help="Add records from a spreadsheet or a calendar.")
+app.add_typer(import_app, name="import")
+
+
+@import_app.command("excel")
+def import_excel(
+    filename: str = typer.Argument(..., help="Records to add, e.g. edited in Excel."),
+    dayfirst: bool = typer.Option(
+        True, help="Read dates like 02/11/2023 as 2 November."
+    ),
+):
+    """
+    Add the records of a file, like one exported with --records and saved back
+    by Excel, updating the notes of the ones already present.
+    """
+    source = FileManager(filename, state["lenient"], lock=False, journal=False)
+    if not source.exists(create=False):
+        raise FileMissingError(f"File {filename} does not exist.")
+    source.dayfirst = dayfirst
+    records = source.load()
+    for record in records:
+        if record[KIND] not in RECORD_KINDS:
+            raise ParseError(
+                f"{filename}: kind {record[KIND]} not supported, "
+                f"use {', '.join(RECORD_KINDS)}."
+            )
+    t = Takt()
+    t.pull()
+    added, edited = t.file_manager.import_edits(records)
+    t.print_console(
+        f"Imported {added} of {len(records)} records from {filename}, "
+        f"{edited} notes updated",
+        style="green",
+    )
+    if added or edited:
+        t.push(
+            f"takt: import {added} records and {edited} notes from "
+            f"{Path(filename).name}"
+        )
+
+
+def read_calendar(source: str) -> str:
+    """Text of an iCalendar file or URL, `webcal://` included."""
+    if re.match(r"(https?|webcal)://", source):
+        url = re.sub(r"^webcal://", "https://", source)
+        try:
+            with urllib.request.urlopen(url, timeout=30) as response:
+                return response.read().decode("utf-8", "replace")
+        except OSError as e:
+            # the URL of a private calendar is a secret, leave it out
+            raise TaktError(f"Can't download the calendar: {e}.")
+    if not Path(source).exists():
+        raise FileMissingError(f"File {source} does not exist.")
+    return Path(source).read_text(encoding="utf-8", errors="replace")
+
+
+@import_app.command("gcal")
+def import_gcal(
+    source: str = typer.Argument(
+        None, help="iCalendar file or URL, `url` in [calendar] by default."
+    ),
+    calendar: str = typer.Option(None, help="Id of a public Google Calendar."),
+    from_: str = typer.Option(None, "--from", help="First day, today by default."),
+    to: str = typer.Option(None, help="Last day, the first one by default."),
+    project: str = typer.Option(
+        None, help="Project of the meetings, `project` in [calendar] by default.",
+        autocompletion=complete_projects,
+    ),
+    dry_run: bool = typer.Option(
+        False, "--dry-run", help="Show the sessions without adding them."
+    ),
+):
+    """
+    Add the meetings of a calendar as sessions named after them.
+
+    Meetings overlapping tracked time, or not over yet, are left out, so
+    importing twice adds nothing.
+    """
+    t = Takt()
+    config = t.config.get("calendar", {})
+    if calendar is not None:
+        source = GOOGLE_CALENDAR.format(quote(calendar, safe="@"))
+    source = source or config.get("url")
+    if not source:
+        raise InvalidArgsError(
+            "Give an iCalendar file or URL, --calendar or `url` in [calendar]."
+        )
+    project = project or config.get("project")
+    start = parse_timestamp(from_).normalize() if from_ else clock.now().normalize()
+    end = parse_timestamp(to).normalize() if to else start
+    if end < start:
+        raise InvalidArgsError("--to can't be before --from.")
+    events = calendar_events(
+        read_calendar(source), start, end + pd.Timedelta(days=1)
+    )
+    # meetings overlapping each other, or back to back, are one session
+    blocks = []
+    for event in events:
+        if blocks and event["start"] <= blocks[-1]["end"]:
+            block = blocks[-1]
+            block["end"] = max(block["end"], event["end"])
+            if event["title"] not in block["titles"]:
+                block["titles"].append(event["title"])
+        else:
+            blocks.append({**event, "titles": [event["title"]]})
+    t.pull()
+    now = clock.now()
+    sessions = t.sessions()
+    table = Table(show_header=True, header_style="bold magenta")
+    for column in ("Start", "End", "Notes", "Status"):
+        table.add_column(column)
+    records, rows = [], []
+    for block in blocks:
+        notes = "; ".join(block["titles"])
+        notes = f"+{project} {notes}" if project else notes
+        # touching a session too, its check out and the check in would tie
+        touching = [
+            s for s in sessions
+            if s['start'] <= block["end"] and block["start"] <= s['end']
+        ]
+        if block["end"] > now:
+            status = "not over yet"
+        elif any(
+            (s['start'], s['end']) == (block["start"], block["end"])
+            for s in touching
+        ):
+            status = "already imported"
+        elif touching:
+            status = "overlaps tracked time"
+        else:
+            status = "would add" if dry_run else "added"
+            records += [
+                FileRow(block["start"], 'in', notes, "source=calendar"),
+                FileRow(block["end"], 'out', ""),
+            ]
+        table.add_row(
+            f"{block['start']:%Y-%m-%d %H:%M}", f"{block['end']:%H:%M}",
+            notes, status,
+        )
+        rows.append({
+            "start": block["start"].isoformat(),
+            "end": block["end"].isoformat(),
+            "notes": notes,
+            "status": status,
+        })
+    if not rows:
+        raise TaktError("No meetings in the calendar for those days.")
+    emit(table, rows)
+    if dry_run or not records:
+        return
+    added = t.file_manager.merge(records)
+    t.print_console(f"Imported {added // 2} meetings", style="green")
+    if added:
+        t.push(f"takt: import {added // 2} meetings")
+
+
+def last_record_index(records) -> int | None:
+    """Index of the record `--last` picks, the newest check or break.
 
-    t.print_console(table)
+    Leave can be recorded ahead and task timers run along, both are skipped.
+    """
+    now = clock.now()
+    return next(
+        (i for i, r in enumerate(records)
+         if r[KIND] not in SIDE_KINDS and r[TIMESTAMP] <= now),
+        None,
+    )
+
+
+def find_record(records, last, at, line, interactive=False) -> int:
+    """Index of the record chosen with `takt edit` or `takt rm` flags."""
+    chosen = (last, at, line, interactive)
+    if sum(x is not None and x is not False for x in chosen) != 1:
+        raise InvalidArgsError("Use one of --last, --at, --line or -i.")
+    if not records:
+        raise TaktError("There are no records.")
+    if interactive:
+        picked = pick_records(records)
+        if not picked:
+            raise TaktError("No record picked.")
+        return picked[0]
+    if last:
+        index = last_record_index(records)
+        if index is None:
+            raise TaktError("There are no checks or breaks.")
+        return index
+    if line is not None:
+        # the header is line 1
+        if not 2 <= line < len(records) + 2:
+            raise InvalidArgsError(f"There is no record on line {line}.")
+        return line - 2
+    timestamp = parse_timestamp(at)
+    matches = [i for i, r in enumerate(records) if r[TIMESTAMP] == timestamp]
+    if not matches:
+        raise InvalidArgsError(f"There is no record at {timestamp}.")
+    if len(matches) > 1:
+        lines = ", ".join(str(i + 2) for i in matches)
+        raise InvalidArgsError(
+            f"Several records at {timestamp}, use --line with one of {lines}."
+        )
+    return matches[0]
 
 
 @app.command()
-def edit():
+def edit(
+    last: bool = typer.Option(False, "--last", help="Edit the newest check or break."),
+    at: str = typer.Option(None, help="Edit the record with this timestamp."),
+    line: int = typer.Option(None, help="Edit the record on this line of the file."),
+    timestamp: str = typer.Option(None, help="New timestamp, prompted if missing."),
+    kind: str = typer.Option(None, help="New kind, prompted if missing."),
+    notes: str = typer.Option(None, help="New notes, prompted if missing."),
+    interactive: bool = typer.Option(
+        False, "--interactive", "-i", help="Pick the record with fuzzy search."
+    ),
+):
     """
-    Edit the records file.
+    Edit a record, or the whole records file in $EDITOR.
     """
+    if last or at is not None or line is not None or interactive:
+        return edit_record(last, at, line, timestamp, kind, notes, interactive)
     editor = os.environ.get(
         'EDITOR',
         'vim',  # Vim by default
     )
     try:
-        subprocess.run([editor, FILE_NAME])
+        subprocess.run([editor, records_file()])
     except FileNotFoundError:
-        typer.echo(
+        raise TaktError(
             f"`{editor}` not found, check if it is installed and accessible."
         )
 
 
 @app.command()
-def summary():
+def rm(
+    last: bool = typer.Option(
+        False, "--last", help="Remove the newest check or break."
+    ),
+    line: int = typer.Option(
+        None, help="Remove the record on this line of the file."
+    ),
+    date: str = typer.Option(None, help="Remove every record of this day."),
+    from_: str = typer.Option(None, "--from", help="Remove records since this time."),
+    to: str = typer.Option(
+        None, help="Remove records until this time, or the end of this day."
+    ),
+    dry_run: bool = typer.Option(False, help="Only show what would be removed."),
+    yes: bool = typer.Option(False, "--yes", "-y", help="Don't ask for confirmation."),
+    force: bool = typer.Option(
+        False, help="Remove even if it leaves a check in or out without pair."
+    ),
+    interactive: bool = typer.Option(
+        False, "--interactive", "-i",
+        help="Pick the records with fuzzy search, tab marks several.",
+    ),
+):
+    """
+    Remove records.
+    """
+    ranged = from_ is not None or to is not None
+    chosen = (last, line is not None, date is not None, ranged, interactive)
+    if sum(chosen) != 1:
+        raise InvalidArgsError(
+            "Use one of --last, --line, --date, --from/--to or -i."
+        )
+    t = Takt()
+    t.pull()
+    records = t.file_manager.load()
+    if interactive:
+        indexes = pick_records(records, multiple=True)
+    elif last:
+        index = last_record_index(records)
+        indexes = [] if index is None else [index]
+    elif line is not None:
+        indexes = [find_record(records, False, None, line)]
+    elif date is not None:
+        day = parse_timestamp(date).date()
+        indexes = [i for i, r in enumerate(records) if r[TIMESTAMP].date() == day]
+    else:
+        start = parse_timestamp(from_) if from_ else pd.Timestamp.min
+        end = parse_range_end(to) if to else pd.Timestamp.max
+        indexes = [i for i, r in enumerate(records) if start <= r[TIMESTAMP] <= end]
+    if not indexes:
+        t.print_console("No records to remove.")
+        return
+
+    table = Table(show_header=True, header_style="bold magenta")
+    for column in COLUMNS:
+        table.add_column(column, style="dim")
+    for i in indexes:
+        table.add_row(*(str(records[i][c]) for c in COLUMNS))
+    console.print(table)
+    chosen = set(indexes)
+    remaining = [r for i, r in enumerate(records) if i not in chosen]
+    if unmatched_checks(remaining) > unmatched_checks(records) and not force:
+        raise TaktError(
+            "Removing these records leaves a check in or out without its "
+            "pair, use --force to remove them anyway."
+        )
+    if dry_run:
+        t.print_console(f"{len(indexes)} records would be removed.")
+        return
+    if not yes and not typer.confirm(f"Remove {len(indexes)} records?"):
+        return
+    t.file_manager.remove([records[i] for i in indexes])
+    t.print_console(f"Removed {len(indexes)} records.", style="green")
+    t.push(f"takt: remove {len(indexes)} records")
+
+
+def edit_record(last, at, line, timestamp, kind, notes, interactive=False):
+    """Edit one record, prompting for the values not given."""
+    t = Takt()
+    t.pull()
+    records = t.file_manager.load()
+    index = find_record(records, last, at, line, interactive)
+    record = records[index]
+    interactive = None in (timestamp, kind, notes)
+    if interactive:
+        t.print_console(
+            f"Editing {record[TIMESTAMP]} {record[KIND]} {record[NOTES]}",
+            style="green",
+        )
+    if timestamp is None:
+        timestamp = typer.prompt("Timestamp", default=str(record[TIMESTAMP]))
+    timestamp = parse_timestamp(timestamp)
+    if kind is None:
+        kind = typer.prompt(
+            "Kind", default=record[KIND], type=click.Choice(RECORD_KINDS)
+        )
+    if kind not in RECORD_KINDS:
+        raise InvalidArgsError(
+            f"Kind {kind} not supported, use {', '.join(RECORD_KINDS)}."
+        )
+    if notes is None:
+        notes = typer.prompt("Notes", default=record[NOTES], show_default=False)
+    new = {TIMESTAMP: timestamp, KIND: kind, NOTES: notes}
+    if all(new[k] == record[k] for k in new):
+        t.print_console("Nothing changed.")
+        return
+    t.file_manager.update(record, new)
+    t.print_console(f"Record updated: {timestamp} {kind} {notes}", style="green")
+    t.push(f"takt: edit record at {timestamp:%Y-%m-%d %H:%M:%S}")
+
+
+@app.command()
+def summary(
+    period: str = typer.Option(
+        "daily", help="Group by daily, wtd, mtd, quarter, half or ytd."
+    ),
+    per_note_top: int = PER_NOTE_TOP_OPTION,
+    notes: bool = NOTES_OPTION,
+    output: str = OUTPUT_OPTION,
+    detail: bool = typer.Option(False, help="List the sessions of each day."),
+    min_duration: str = MIN_DURATION_OPTION,
+    earnings: bool = EARNINGS_OPTION,
+):
     """
-    Daily summary.
+    Daily summary, or by the given period.
     """
     t = Takt()
-    summary_dict = t.aggregate(period='daily')
-    display_summary_table(summary_dict)
+    summary_dict = t.aggregate(
+        period=period, min_duration=min_duration, recent=SUMMARY_LIMIT + 1
+    )
+    sessions = t.sessions_by_group() if detail else None
+    display_summary_table(
+        summary_dict,
+        top_notes=t.top_notes(per_note_top),
+        sessions=sessions,
+        show_notes=notes,
+        output=output,
+        report=t.report_options(),
+        earnings=t.earnings() if earnings else None,
+        adjustments=t.adjustments(),
+    )
 
 
 @app.command()
-def wtd():
+def wtd(
+    per_note_top: int = PER_NOTE_TOP_OPTION,
+    notes: bool = NOTES_OPTION,
+    output: str = OUTPUT_OPTION,
+    week_start: str = typer.Option(
+        None, help="First day of the week: sunday or monday."
+    ),
+    min_duration: str = MIN_DURATION_OPTION,
+    earnings: bool = EARNINGS_OPTION,
+):
     """
     Week to date summary.
     """
     t = Takt()
-    list_dict = t.aggregate(period='wtd')
-    display_summary_table(list_dict)
+    list_dict = t.aggregate(
+        period='wtd', week_start=week_start, min_duration=min_duration,
+        recent=SUMMARY_LIMIT + 1,
+    )
+    display_summary_table(
+        list_dict, top_notes=t.top_notes(per_note_top),
+        show_notes=notes,
+        output=output,
+        report=t.report_options(),
+        earnings=t.earnings() if earnings else None,
+        adjustments=t.adjustments(),
+    )
 
 
 @app.command()
-def ytd():
+def ytd(
+    per_note_top: int = PER_NOTE_TOP_OPTION,
+    notes: bool = NOTES_OPTION,
+    output: str = OUTPUT_OPTION,
+    min_duration: str = MIN_DURATION_OPTION,
+    earnings: bool = EARNINGS_OPTION,
+):
     """
     Year to date summary.
     """
     t = Takt()
-    list_dict = t.aggregate(period='ytd')
-    display_summary_table(list_dict)
+    list_dict = t.aggregate(period='ytd', min_duration=min_duration)
+    display_summary_table(
+        list_dict, top_notes=t.top_notes(per_note_top),
+        show_notes=notes,
+        output=output,
+        report=t.report_options(),
+        earnings=t.earnings() if earnings else None,
+        adjustments=t.adjustments(),
+    )
+
+
+@app.command()
+def team(
+    filenames: list[str] = typer.Argument(
+        ..., help="Records file of each member who opted in."
+    ),
+    weeks: int = typer.Option(TEAM_WEEKS, min=1, help="Number of weeks shown."),
+    output: str = OUTPUT_OPTION,
+):
+    """
+    Weekly hours of a team by project, without telling who worked them.
+    """
+    week_start = load_config().get("week_start", DEFAULT_WEEK_START)
+    table = Table(show_header=True, header_style="bold magenta")
+    for column in ("Week", "Project", "Hours", "Members"):
+        table.add_column(column, style="dim")
+    rows = []
+    for row in team_summary(filenames, weeks, week_start):
+        for project, duration in row['projects']:
+            table.add_row(
+                row['group'], project or "other", format_time(duration), ""
+            )
+            rows.append({
+                "week": row['group'],
+                "project": project,
+                "hours": to_hours(duration),
+                "members": None,
+            })
+        table.add_row(
+            row['group'], "total", format_time(row['duration']),
+            str(row['members']), style="bold", end_section=True,
+        )
+        rows.append({
+            "week": row['group'],
+            "project": None,
+            "hours": to_hours(row['duration']),
+            "members": row['members'],
+        })
+    emit(table, rows, output)
 
 
 @app.command()
-def mtd():
+def mtd(
+    per_note_top: int = PER_NOTE_TOP_OPTION,
+    notes: bool = NOTES_OPTION,
+    output: str = OUTPUT_OPTION,
+    min_duration: str = MIN_DURATION_OPTION,
+    earnings: bool = EARNINGS_OPTION,
+    burnup: bool = typer.Option(
+        False, help="Cumulative hours of the month against the target line."
+    ),
+    width: int = typer.Option(40, min=10, help="Width of the burn-up bars."),
+):
     """
     Month to date summary.
     """
     t = Takt()
-    summary_dict = t.aggregate(period='mtd')
-    display_summary_table(summary_dict)
+    if burnup:
+        today = clock.now().normalize()
+        first = today.replace(day=1)
+        last = first + pd.offsets.MonthEnd(0)
+        target = parse_duration(t.report_options().get("target", DAILY_TARGET))
+        rows = burnup_rows(
+            t.daily_totals(), t.leave(), t.holidays(first, last),
+            first, last, target, today,
+        )
+        display_burnup(rows, f"{first:%B %Y}", width, output)
+        return
+    summary_dict = t.aggregate(
+        period='mtd', min_duration=min_duration, recent=SUMMARY_LIMIT + 1
+    )
+    display_summary_table(
+        summary_dict, top_notes=t.top_notes(per_note_top),
+        show_notes=notes,
+        output=output,
+        report=t.report_options(),
+        earnings=t.earnings() if earnings else None,
+        adjustments=t.adjustments(),
+    )
+
+
+@app.command()
+def quarter(
+    per_note_top: int = PER_NOTE_TOP_OPTION,
+    notes: bool = NOTES_OPTION,
+    output: str = OUTPUT_OPTION,
+    min_duration: str = MIN_DURATION_OPTION,
+    earnings: bool = EARNINGS_OPTION,
+):
+    """
+    Quarterly summary.
+    """
+    t = Takt()
+    summary_dict = t.aggregate(period='quarter', min_duration=min_duration)
+    display_summary_table(
+        summary_dict, top_notes=t.top_notes(per_note_top),
+        show_notes=notes,
+        output=output,
+        report=t.report_options(),
+        earnings=t.earnings() if earnings else None,
+        adjustments=t.adjustments(),
+    )
+
+
+@app.command()
+def half(
+    per_note_top: int = PER_NOTE_TOP_OPTION,
+    notes: bool = NOTES_OPTION,
+    output: str = OUTPUT_OPTION,
+    min_duration: str = MIN_DURATION_OPTION,
+    earnings: bool = EARNINGS_OPTION,
+):
+    """
+    Half-year summary.
+    """
+    t = Takt()
+    summary_dict = t.aggregate(period='half', min_duration=min_duration)
+    display_summary_table(
+        summary_dict, top_notes=t.top_notes(per_note_top),
+        show_notes=notes,
+        output=output,
+        report=t.report_options(),
+        earnings=t.earnings() if earnings else None,
+        adjustments=t.adjustments(),
+    )
+
+
+def command_tree(ctx):
+    """Yield `ctx` and the contexts of its visible subcommands, depth first."""
+    yield ctx
+    command = ctx.command
+    if not isinstance(command, click.Group):
+        return
+    for name in command.list_commands(ctx):
+        sub = command.get_command(ctx, name)
+        if sub is not None and not sub.hidden:
+            yield from command_tree(click.Context(sub, info_name=name, parent=ctx))
+
+
+def command_doc(ctx) -> dict:
+    """Help, usage, arguments and options of the command of `ctx`."""
+    command = ctx.command
+    arguments, options = [], []
+    for param in command.get_params(ctx):
+        if isinstance(param, click.Argument):
+            arguments.append(
+                (param.human_readable_name, getattr(param, "help", None) or "")
+            )
+        else:
+            record = param.get_help_record(ctx)
+            if record is not None:
+                options.append(record)
+    return {
+        "name": ctx.command_path,
+        "help": inspect.cleandoc(command.help or ""),
+        "usage": " ".join([ctx.command_path, *command.collect_usage_pieces(ctx)]),
+        "arguments": arguments,
+        "options": options,
+    }
+
+
+def docs_markdown(ctx) -> str:
+    """Reference of every command in Markdown."""
+    parts = []
+    for sub in command_tree(ctx):
+        doc = command_doc(sub)
+        heading = "#" if sub.parent is None else "##"
+        parts.append(f"{heading} `{doc['name']}`\n")
+        if doc["help"]:
+            parts.append(f"{doc['help']}\n")
+        parts.append(f"**Usage**:\n\n```console\n$ {doc['usage']}\n```\n")
+        for title, params in (
+            ("Arguments", doc["arguments"]), ("Options", doc["options"])
+        ):
+            if params:
+                parts.append(f"**{title}**:\n")
+                parts.append("\n".join(
+                    f"* `{name}`" + (f": {text}" if text else "")
+                    for name, text in params
+                ) + "\n")
+    return "\n".join(parts)
+
+
+def roff_escape(text: str) -> str:
+    text = text.replace("\\", "\\e").replace("-", "\\-")
+    # blank lines start a paragraph, a leading dot or quote is a request
+    text = re.sub(r"^\s*$", ".PP", text, flags=re.MULTILINE)
+    return re.sub(r"^(?=['.](?!PP$))", r"\\&", text, flags=re.MULTILINE)
+
+
+def docs_man(ctx, version: str, date: str) -> str:
+    """Man page, section 1, of every command in roff."""
+    root, *subs = command_tree(ctx)
+    doc = command_doc(root)
+    summary = doc["help"].splitlines()[0] if doc["help"] else ""
+    lines = [
+        f'.TH TAKT 1 "{date}" "takt {version}" "User Commands"',
+        ".SH NAME",
+        f"takt \\- {roff_escape(summary)}",
+        ".SH SYNOPSIS",
+        roff_escape(doc["usage"]),
+        ".SH OPTIONS",
+    ]
+    for name, text in doc["options"]:
+        lines += [".TP", f".B {roff_escape(name)}", roff_escape(text)]
+    lines.append(".SH COMMANDS")
+    for sub in subs:
+        doc = command_doc(sub)
+        lines.append(f'.SS "{roff_escape(doc["usage"])}"')
+        if doc["help"]:
+            lines.append(roff_escape(doc["help"]))
+        for name, text in doc["arguments"] + doc["options"]:
+            lines += [".TP", f".B {roff_escape(name)}", roff_escape(text)]
+    return "\n".join(lines) + "\n"
+
+
+docs_app = typer.Typer(help="Generate the reference of every command.")
+app.add_typer(docs_app, name="docs")
+
+
+def takt_version() -> str:
+    try:
+        return importlib.metadata.version("takt")
+    except importlib.metadata.PackageNotFoundError:
+        return "dev"
+
+
+@docs_app.command("man")
+def docs_man_cmd(output: str = OUTPUT_OPTION):
+    """
+    Man page of takt and its commands, e.g. `takt docs man -o takt.1`.
+    """
+    # reproducible builds set SOURCE_DATE_EPOCH
+    epoch = os.getenv("SOURCE_DATE_EPOCH")
+    date = pd.Timestamp(int(epoch), unit="s") if epoch else clock.now()
+    root = typer.main.get_command(app)
+    text = docs_man(
+        click.Context(root, info_name="takt"), takt_version(), f"{date:%Y-%m-%d}"
+    )
+    if output is None:
+        sys.stdout.write(text)
+    else:
+        write_atomic(output, text)
+
+
+@docs_app.command("markdown")
+def docs_markdown_cmd(output: str = OUTPUT_OPTION):
+    """
+    Markdown reference of takt and its commands.
+    """
+    root = typer.main.get_command(app)
+    text = docs_markdown(click.Context(root, info_name="takt"))
+    if output is None:
+        sys.stdout.write(text)
+    else:
+        write_atomic(output, text)
+
+
+@app.command()
+def completion(
+    shell: str = typer.Argument(..., help="bash, zsh, fish or powershell."),
+):
+    """
+    Print the shell completion script, e.g. `eval "$(takt completion bash)"`.
+    """
+    if shell not in COMPLETION_SHELLS:
+        raise InvalidArgsError(
+            f"Shell {shell} not supported, use {', '.join(COMPLETION_SHELLS)}."
+        )
+    # the script of `--show-completion`, which only knows the current shell,
+    # building the command registers the typer shells, powershell included
+    root = typer.main.get_command(app)
+    complete = get_completion_class(shell)
+    if complete is None:
+        raise TaktError(f"Completion for {shell} is not available.")
+    typer.echo(complete(root, {}, "takt", "_TAKT_COMPLETE").source())
+
+
+taskwarrior_app = typer.Typer(help="Follow the active Taskwarrior task.")
+app.add_typer(taskwarrior_app, name="taskwarrior")
+
+
+@taskwarrior_app.command("hook")
+def taskwarrior_hook():
+    """
+    Taskwarrior on-modify hook: check in when a task starts, out when it stops.
+
+    Taskwarrior passes the task before and after the change on stdin and
+    expects the changed task back on stdout, extra lines are shown as feedback.
+    """
+    before = json.loads(sys.stdin.readline())
+    after_line = sys.stdin.readline()
+    after = json.loads(after_line)
+    typer.echo(after_line.strip())
+    # changes made by `takt check --task` are already recorded
+    if os.getenv(TASKWARRIOR_ENV):
+        return
+    started = "start" in after and "start" not in before
+    stopped = "start" in before and "start" not in after
+    if not started and not stopped:
+        return
+    t = Takt()
+    notes = after.get("description", "") if started else ""
+    meta = format_meta({"taskwarrior": after.get("uuid", "")})
+    try:
+        last = t.last_check()
+        if started == (last is not None and last[KIND] != 'out'):
+            return
+        kind, timestamp = t.check(notes, meta)
+    except TaktError as e:
+        # a failing hook makes Taskwarrior reject the change
+        typer.echo(f"takt: {e}")
+        return
+    typer.echo(f"takt: check {kind} at {timestamp:%Y-%m-%d %H:%M:%S}")
+    run_hook(t, "post_check", {TIMESTAMP: timestamp, KIND: kind, NOTES: notes})
+    update_presence(t, kind, notes)
+
+
+@taskwarrior_app.command("install")
+def taskwarrior_install(
+    force: bool = typer.Option(
+        False, "--force", "-f", help="Overwrite an existing hook."
+    ),
+):
+    """
+    Install the takt hook in Taskwarrior.
+    """
+    hook = Path(os.path.expanduser(TASKWARRIOR_HOOK))
+    if hook.exists() and not force:
+        raise TaktError(f"{hook} already exists, use --force to overwrite it.")
+    hook.parent.mkdir(parents=True, exist_ok=True)
+    hook.write_text("#!/bin/sh\nexec takt taskwarrior hook\n")
+    hook.chmod(0o755)
+    typer.echo(f"Installed {hook}")
+
+
+project_app = typer.Typer(help="Set up projects in the config.")
+app.add_typer(project_app, name="project")
+
+
+@project_app.command("new")
+def project_new(
+    name: str = typer.Argument(..., help="Project, as tagged in notes: +NAME."),
+    rate: float = typer.Option(None, help="Hourly rate, see [rates]."),
+    budget: str = typer.Option(None, help="Estimated time, e.g. 60h."),
+    currency: str = typer.Option(None, help="Currency of its invoices."),
+    tags: list[str] = typer.Option(None, "--tags", help="Tags, e.g. billable."),
+):
+    """
+    Add a project to the config: its rate, budget, currency and tags.
+    """
+    if not re.fullmatch(r"[\w.-]+", name):
+        raise InvalidArgsError(
+            f"Project {name!r} can't be tagged, use letters, digits, . _ or -."
+        )
+    if budget is not None:
+        parse_duration(budget)
+    config = load_config()
+    if name in project_names(config):
+        raise InvalidArgsError(f"Project {name} is already in {CONFIG_FILE}.")
+    path = Path(CONFIG_FILE)
+    text = path.read_text() if path.exists() else ""
+    if rate is not None:
+        text = set_config_key(text, "rates.projects", name, rate)
+    if budget is not None:
+        text = set_config_key(text, "estimates.projects", name, budget)
+    # a table named after the project is renamed along with it
+    table = f"projects.{toml_key(name)}"
+    text = set_config_key(text, table, "created", f"{clock.now():%Y-%m-%d}")
+    if currency is not None:
+        text = set_config_key(text, table, "currency", currency)
+    if tags:
+        tags = [tag.strip() for value in tags for tag in value.split(",")]
+        text = set_config_key(text, table, "tags", tags)
+    try:
+        tomllib.loads(text)
+    except tomllib.TOMLDecodeError as e:
+        raise ParseError(f"{CONFIG_FILE} would not be valid TOML: {e}")
+    path.parent.mkdir(parents=True, exist_ok=True)
+    write_atomic(str(path), text)
+    Takt.print_console(f"Project {name} added to {CONFIG_FILE}.", style="green")
+
+
+@project_app.command("list")
+def project_list():
+    """
+    List the projects in the config with their rate, budget and tags.
+    """
+    config = load_config()
+    rates = config.get("rates", {})
+    budgets = config.get("estimates", {}).get("projects", {})
+    table = Table(show_header=True, header_style="bold magenta")
+    for column in ("Project", "Rate", "Budget", "Currency", "Tags"):
+        table.add_column(column, style="dim")
+    rows = []
+    currency = rates.get("currency", DEFAULT_CURRENCY)
+    for name in sorted(project_names(config)):
+        project = config.get("projects", {}).get(name, {})
+        row = {
+            "project": name,
+            "rate": rates.get("projects", {}).get(name, rates.get("default")),
+            "budget": budgets.get(name),
+            "currency": project.get("currency", currency),
+            "tags": project.get("tags", []),
+        }
+        table.add_row(*(
+            ", ".join(v) if isinstance(v, list) else "" if v is None else str(v)
+            for v in row.values()
+        ))
+        rows.append(row)
+    emit(table, rows)
+
+
+push_app = typer.Typer(help="Log the tracked time in other services.")
+app.add_typer(push_app, name="push")
+
+
+def save_pushed(pushed: dict):
+    """Write the ids of the worklogs pushed, by service."""
+    os.makedirs(STATE_DIR, exist_ok=True)
+    write_atomic(PUSHED_FILE, json.dumps(pushed, indent=2) + "\n")
+
+
+def jira_worklog(config, key, session, duration) -> str:
+    """Add a worklog to the Jira issue `key`, return its id.
+
+    Jira Cloud takes `email` and an API token, Server and Data Center a
+    personal access token alone.
+    """
+    url = config.get("url")
+    token = config.get("token")
+    if not url or not token:
+        raise InvalidArgsError("Set `url` and `token` in [jira].")
+    if config.get("email"):
+        credentials = base64.b64encode(f"{config['email']}:{token}".encode())
+        auth = f"Basic {credentials.decode()}"
+    else:
+        auth = f"Bearer {token}"
+    started = session['start'].to_pydatetime().astimezone()
+    payload = {
+        "started": started.strftime("%Y-%m-%dT%H:%M:%S.000%z"),
+        "timeSpentSeconds": int(duration.total_seconds()),
+        "comment": session['notes'],
+    }
+    request = urllib.request.Request(
+        f"{url.rstrip('/')}/rest/api/2/issue/{quote(key)}/worklog",
+        data=json.dumps(payload).encode(),
+        headers={"Authorization": auth, "Content-Type": "application/json"},
+        method="POST",
+    )
+    with urllib.request.urlopen(request, timeout=30) as response:
+        return str(json.load(response).get("id", ""))
+
+
+@push_app.command("jira")
+def push_jira(
+    from_: str = typer.Option(None, "--from", help="First day, today by default."),
+    to: str = typer.Option(None, help="Last day of the range."),
+    dry_run: bool = typer.Option(
+        False, "--dry-run", help="Show the worklogs without adding them."
+    ),
+):
+    """
+    Log the sessions mentioning Jira issues, e.g. PROJ-123, as worklogs.
+
+    Sessions already pushed, still open or shorter than a minute are skipped.
+    """
+    t = Takt()
+    config = t.config.get("jira", {})
+    from_ = from_ or f"{clock.now() - t.day_start:%Y-%m-%d}"
+    path = Path(PUSHED_FILE)
+    pushed = json.loads(path.read_text()) if path.exists() else {}
+    done = pushed.setdefault("jira", {})
+    table = Table(show_header=True, header_style="bold magenta")
+    for column in ("Issue", "Start", "Hours", "Status"):
+        table.add_column(column, style="dim")
+    rows, failed = [], 0
+    projects = config.get("projects")
+    # the open session ends now, inferred ones were closed by auto check out
+    now = clock.now()
+    for key, session, share in issue_sessions(t, from_, to):
+        if not JIRA_KEY.fullmatch(key):
+            continue
+        # words like UTF-8 look like keys too
+        if projects and key.split("-")[0] not in projects:
+            continue
+        # one worklog per issue and session, the session start names it
+        id_ = f"{key}@{session['start'].isoformat()}"
+        if id_ in done:
+            status = "already pushed"
+        elif session['end'] >= now:
+            status = "still open"
+        elif share < pd.Timedelta(minutes=1):
+            status = "too short"
+        elif dry_run:
+            status = "would push"
+        else:
+            try:
+                done[id_] = jira_worklog(config, key, session, share)
+                status = "pushed"
+            except (OSError, ValueError) as e:
+                status = f"failed: {e}"
+                failed += 1
+            else:
+                # saved right away, a later failure can't push it twice
+                save_pushed(pushed)
+        table.add_row(
+            key, f"{session['start']:%Y-%m-%d %H:%M}", format_time(share), status
+        )
+        rows.append({
+            "issue": key,
+            "start": session['start'].isoformat(),
+            "hours": to_hours(share),
+            "status": status,
+        })
+    if not rows:
+        raise TaktError("No sessions mentioning Jira issues in the range.")
+    emit(table, rows)
+    if failed:
+        raise TaktError(f"{failed} worklogs failed, run it again to retry them.")
+
+
+queue_app = typer.Typer(
+    help="Inspect the failed hooks and presence updates waiting to be retried."
+)
+app.add_typer(queue_app, name="queue")
+
+
+@queue_app.command("list")
+def queue_list():
+    """
+    List the queued hooks with their attempts and next retry.
+    """
+    table = Table(show_header=True, header_style="bold magenta")
+    for column in ("Id", "Hook", "Created", "Attempts", "Next retry", "Error"):
+        table.add_column(column, style="dim")
+    rows = []
+    for entry in load_queue():
+        row = {
+            "id": entry["id"],
+            "hook": entry["hook"],
+            "created": entry["created"],
+            "attempts": entry["attempts"],
+            "next": entry["next"],
+            "error": entry["error"],
+        }
+        table.add_row(*(str(v) for v in row.values()))
+        rows.append(row)
+    emit(table, rows)
+
+
+@queue_app.command("retry")
+def queue_retry(
+    ids: list[str] = typer.Argument(
+        None, help="Entries to retry, all by default."
+    ),
+):
+    """
+    Retry queued hooks now, without waiting for their next retry.
+    """
+    done, failed = retry_queue(ids or None, force=True)
+    style = "yellow" if failed else "green"
+    Takt.print_console(f"Retried hooks: {done} done, {failed} failed.", style=style)
+
+
+@queue_app.command("drop")
+def queue_drop(
+    ids: list[str] = typer.Argument(None, help="Entries to drop."),
+    all_entries: bool = typer.Option(
+        False, "--all", help="Drop every entry."
+    ),
+):
+    """
+    Remove queued hooks without running them.
+    """
+    if not ids and not all_entries:
+        raise InvalidArgsError("Give the entries to drop or --all.")
+    with queue_lock():
+        entries = load_queue()
+        missing = set(ids or ()) - {e["id"] for e in entries}
+        if missing:
+            raise InvalidArgsError(
+                f"Not in the queue: {', '.join(sorted(missing))}."
+            )
+        kept = []
+        for entry in entries:
+            if all_entries or entry["id"] in ids:
+                drop_queued(entry)
+            else:
+                kept.append(entry)
+        save_queue(kept)
+    Takt.print_console(f"Dropped {len(entries) - len(kept)} hooks.", style="green")
+
+
+profile_app = typer.Typer(help="Manage profiles, each with its records file.")
+app.add_typer(profile_app, name="profile")
+
+
+@profile_app.command("list")
+def profile_list():
+    """
+    List the profiles defined in the config.
+    """
+    config = load_config()
+    current = active_profile(config)
+    marker = "*" if current is None else " "
+    typer.echo(f"{marker} (default) {FILE_NAME}")
+    for name, profile in config.get("profiles", {}).items():
+        marker = "*" if name == current else " "
+        typer.echo(f"{marker} {name} {os.path.expanduser(profile['file'])}")
+
+
+@profile_app.command("switch")
+def profile_switch(
+    name: str = typer.Argument(
+        None, help="Profile to use by default, omit it to use TAKT_FILE.",
+        autocompletion=complete_profiles,
+    ),
+):
+    """
+    Set the profile used when `--profile` is not given.
+    """
+    config = load_config()
+    if name is not None and name not in config.get("profiles", {}):
+        raise InvalidArgsError(f"Profile {name} not found in {CONFIG_FILE}.")
+    os.makedirs(STATE_DIR, exist_ok=True)
+    Path(PROFILE_FILE).write_text(name or "")
+    Takt.print_console(f"Using profile {name or '(default)'}.", style="green")
+
+
+all_app = typer.Typer(help="Summaries of every profile together.")
+app.add_typer(all_app, name="all")
+
+
+def profile_summaries(period) -> dict[str, list[dict]]:
+    """Summary of each profile with records, by profile name."""
+    profiles = load_config().get("profiles", {})
+    if not profiles:
+        raise InvalidArgsError(f"No [profiles] in {CONFIG_FILE}.")
+    previous = state["profile"]
+    summaries = {}
+    try:
+        for name in profiles:
+            state["profile"] = name
+            t = Takt()
+            if not t.file_manager.head(1):
+                continue
+            summaries[name] = t.aggregate(period, recent=SUMMARY_LIMIT + 1)
+    finally:
+        state["profile"] = previous
+    return summaries
+
+
+def display_profile_summaries(period, output):
+    """One line per profile and group and their total, newest groups first."""
+    summaries = profile_summaries(period)
+    groups = sorted(
+        {row['group'] for rows in summaries.values() for row in rows},
+        reverse=True,
+    )[:SUMMARY_LIMIT + 1]
+    if not groups:
+        raise TaktError("No records in any profile.")
+    table = Table(show_header=True, header_style="bold magenta")
+    for column in ("Date", "Profile", "Hours", "N.Days"):
+        table.add_column(column, style="dim")
+    rows = []
+    for group in groups:
+        total, dates = pd.Timedelta(0), set()
+        for name, summary in summaries.items():
+            row = next((r for r in summary if r['group'] == group), None)
+            if row is None:
+                continue
+            total += row['duration']
+            dates |= set(row['dates'])
+            table.add_row(
+                group, name, format_time(row['duration']), str(len(row['dates']))
+            )
+            rows.append({
+                "group": group,
+                "profile": name,
+                "hours": to_hours(row['duration']),
+                "days": len(row['dates']),
+            })
+        table.add_row(
+            group, "total", format_time(total), str(len(dates)), style="bold",
+            end_section=True,
+        )
+        rows.append({
+            "group": group,
+            "profile": None,
+            "hours": to_hours(total),
+            "days": len(dates),
+        })
+    emit(table, rows, output)
+
+
+@all_app.command("day")
+def all_day(output: str = OUTPUT_OPTION):
+    """
+    Daily hours of every profile and their total.
+    """
+    display_profile_summaries("daily", output)
+
+
+@all_app.command("week")
+def all_week(output: str = OUTPUT_OPTION):
+    """
+    Weekly hours of every profile and their total.
+    """
+    display_profile_summaries("wtd", output)
+
+
+@all_app.command("month")
+def all_month(output: str = OUTPUT_OPTION):
+    """
+    Monthly hours of every profile and their total.
+    """
+    display_profile_summaries("mtd", output)
+
 
+def main():
+    """Run the CLI, reporting takt errors on stderr with their exit code."""
+    plugins.update(load_plugins("takt_"))
+    try:
+        app()
+    except TaktError as e:
+        if state["json"]:
+            sys.stderr.write(json.dumps(e.to_dict()) + "\n")
+        else:
+            err_console.print(f"[red]Error:[/] {e}")
+        sys.exit(e.exit_code)
+    finally:
+        if state["trace"]:
+            sys.stderr.write(trace.report() + "\n")
 
-plugins = load_plugins("takt_")
 
 if __name__ == "__main__":
-    app()
+    main()
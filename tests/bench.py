@@ -0,0 +1,65 @@
+"""Time takt on a large records file, run with `make bench`.
+
+    python tests/bench.py [ROWS]
+
+The records, 12 one hour sessions a day going back from yesterday, are
+written once to the state dir below and reused while ROWS doesn't change.
+Each command runs twice, the first read of a new file also builds its month
+index.
+"""
+import os
+import subprocess
+import sys
+import tempfile
+import time
+from datetime import datetime, timedelta
+from pathlib import Path
+
+TAKT = Path(__file__).resolve().parent.parent / "takt.py"
+COMMANDS = (["status"], ["wtd"], ["display", "--head", "20"])
+SESSIONS = 12
+
+
+def generate(path, rows):
+    """Write `rows` records, newest first."""
+    day = datetime.now().replace(hour=0, minute=0, second=0, microsecond=0)
+    with open(path, "w") as f:
+        f.write("timestamp,kind,notes\n")
+        written = 0
+        while written < rows:
+            day -= timedelta(days=1)
+            for hour in reversed(range(8, 8 + SESSIONS)):
+                start = day + timedelta(hours=hour)
+                f.write(f"{start + timedelta(minutes=50)},out,\n")
+                f.write(f"{start},in,+bench session {hour}\n")
+                written += 2
+
+
+def main():
+    rows = int(sys.argv[1]) if len(sys.argv) > 1 else 1_000_000
+    state = Path(tempfile.gettempdir(), "takt-bench")
+    state.mkdir(exist_ok=True)
+    records = state / f"takt-{rows}.csv"
+    if not records.exists():
+        print(f"Writing {rows} records to {records}")
+        generate(records, rows)
+    env = {
+        **os.environ,
+        "TAKT_FILE": str(records),
+        "TAKT_CONFIG": str(state / "config.toml"),
+        "XDG_STATE_HOME": str(state),
+    }
+    env.pop("TAKT_PROFILE", None)
+    for args in COMMANDS:
+        for run in ("cold", "warm"):
+            start = time.perf_counter()
+            subprocess.run(
+                [sys.executable, str(TAKT), *args], env=env, check=True,
+                stdout=subprocess.DEVNULL,
+            )
+            elapsed = time.perf_counter() - start
+            print(f"{' '.join(args):<20} {run}  {elapsed:6.2f}s")
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,37 @@
+import os
+import sys
+import tempfile
+from pathlib import Path
+
+import pytest
+
+# takt reads these when imported, keep the tests away from the real files
+STATE = tempfile.mkdtemp(prefix="takt-tests-")
+os.environ["XDG_STATE_HOME"] = STATE
+os.environ["TAKT_CONFIG"] = os.path.join(STATE, "config.toml")
+os.environ.pop("TAKT_PROFILE", None)
+os.environ.pop("TAKT_HOOK", None)
+sys.path.insert(0, str(Path(__file__).resolve().parent.parent))
+
+import takt  # noqa: E402
+
+
+@pytest.fixture
+def records(tmp_path, monkeypatch):
+    """Path of an empty records file used by every takt command."""
+    path = tmp_path / "takt.csv"
+    monkeypatch.setattr(takt, "FILE_NAME", str(path))
+    monkeypatch.setenv("TAKT_FILE", str(path))
+    return path
+
+
+@pytest.fixture
+def config():
+    """Write the config file, removed after the test."""
+    path = Path(takt.CONFIG_FILE)
+
+    def write(text):
+        path.write_text(text)
+
+    yield write
+    path.unlink(missing_ok=True)
@@ -0,0 +1,62 @@
+import pandas as pd
+import pytest
+
+import takt
+
+
+def sessions(day, minutes, count):
+    """Records of `count` sessions of `minutes` each, newest first."""
+    records = []
+    start = pd.Timestamp(day) + pd.Timedelta(hours=8)
+    for n in range(count):
+        check_in = start + pd.Timedelta(hours=n)
+        records += [
+            takt.FileRow(check_in, "in", ""),
+            takt.FileRow(check_in + pd.Timedelta(minutes=minutes), "out", ""),
+        ]
+    return sorted(records, key=lambda r: r[takt.TIMESTAMP], reverse=True)
+
+
+def test_sums_are_exact():
+    # ten times 0.1 hours isn't 1.0 in float hours
+    rows = takt.Aggregator("daily", verbose=False).calculate(
+        sessions("2024-07-01", 6, 10)
+    )
+    assert rows[0]["duration"] == pd.Timedelta(hours=1)
+    assert takt.format_time(rows[0]["duration"]) == "01:00"
+
+
+def test_sums_over_a_month():
+    records = []
+    for day in pd.date_range("2024-07-01", "2024-07-31"):
+        records += sessions(day, 20, 3)
+    records.sort(key=lambda r: r[takt.TIMESTAMP], reverse=True)
+    rows = takt.Aggregator("mtd", verbose=False).calculate(records)
+    assert rows[0]["duration"] == pd.Timedelta(hours=31)
+    assert takt.to_hours(rows[0]["duration"]) == 31.0
+    assert takt.format_time(rows[0]["duration"]) == "31:00"
+
+
+def test_seconds_are_kept_until_rendering():
+    records = sessions("2024-07-01", 0, 1)
+    records[0][takt.TIMESTAMP] += pd.Timedelta(seconds=59)
+    rows = takt.Aggregator("daily", verbose=False).calculate(records)
+    assert rows[0]["duration"] == pd.Timedelta(seconds=59)
+    assert takt.format_time(rows[0]["duration"]) == "00:00"
+
+
+def test_quarter_and_half_groups():
+    records = sessions("2024-09-30", 60, 2) + sessions("2024-10-01", 60, 1)
+    records.sort(key=lambda r: r[takt.TIMESTAMP], reverse=True)
+    rows = takt.Aggregator("quarter", verbose=False).calculate(records)
+    assert {r["group"]: r["duration"] for r in rows} == {
+        "2024-Q4": pd.Timedelta(hours=1),
+        "2024-Q3": pd.Timedelta(hours=2),
+    }
+    rows = takt.Aggregator("half", verbose=False).calculate(records)
+    assert [r["group"] for r in rows] == ["2024-H2"]
+
+
+def test_unknown_period_lists_the_supported_ones():
+    with pytest.raises(takt.InvalidArgsError, match="use daily, wtd, mtd"):
+        takt.Aggregator("fortnight", verbose=False)
@@ -0,0 +1,34 @@
+import base64
+from types import SimpleNamespace
+
+import pytest
+
+import takt
+
+
+def request(user, password):
+    credentials = base64.b64encode(f"{user}:{password}".encode()).decode()
+    return SimpleNamespace(headers={"Authorization": f"Basic {credentials}"})
+
+
+def test_basic_auth_with_salted_hashes():
+    stored = takt.hash_password("s3cret", iterations=10)
+    assert stored != takt.hash_password("s3cret", iterations=10)
+    auth = takt.BasicAuth({"alice": stored})
+    assert auth.authenticate(request("alice", "s3cret")) == "alice"
+    assert auth.authenticate(request("alice", "wrong")) is None
+    assert auth.authenticate(request("bob", "s3cret")) is None
+
+
+def test_basic_auth_refuses_unsalted_digests():
+    digest = "5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8"
+    with pytest.raises(takt.InvalidArgsError):
+        takt.BasicAuth({"alice": digest})
+
+
+def test_oidc_cache_is_bounded(monkeypatch):
+    monkeypatch.setattr(takt, "OIDC_CACHE_SIZE", 3)
+    auth = takt.OIDCAuth("https://sso.example.com")
+    for n in range(10):
+        auth.remember(f"token-{n}", f"user-{n}")
+    assert list(auth._cache) == ["token-7", "token-8", "token-9"]
@@ -0,0 +1,11 @@
+import pytest
+from typer.testing import CliRunner
+
+import takt
+
+
+@pytest.mark.parametrize("shell", takt.COMPLETION_SHELLS)
+def test_completion_script(shell):
+    result = CliRunner().invoke(takt.app, ["completion", shell])
+    assert result.exit_code == 0, result.output
+    assert "_TAKT_COMPLETE" in result.output
@@ -0,0 +1,21 @@
+import pytest
+
+import takt
+
+
+def test_unset_variable_is_an_error(config, monkeypatch):
+    monkeypatch.delenv("TAKT_TEST_SECRET", raising=False)
+    config('[serve]\nfeed_secret = "${TAKT_TEST_SECRET}"\n')
+    with pytest.raises(takt.ParseError, match="serve.feed_secret"):
+        takt.load_config(takt.CONFIG_FILE)
+
+
+def test_variables_are_expanded_but_not_in_hooks(config, monkeypatch):
+    monkeypatch.setenv("TAKT_TEST_SECRET", "s3cret")
+    config(
+        '[serve]\nfeed_secret = "$TAKT_TEST_SECRET"\n'
+        '[hooks]\npost_check = "echo $TAKT_KIND"\n'
+    )
+    loaded = takt.load_config(takt.CONFIG_FILE)
+    assert loaded["serve"]["feed_secret"] == "s3cret"
+    assert loaded["hooks"]["post_check"] == "echo $TAKT_KIND"
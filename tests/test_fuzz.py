@@ -0,0 +1,87 @@
+import codecs
+import io
+
+import pandas as pd
+from hypothesis import HealthCheck, given, settings, strategies as st
+
+import takt
+
+NOTES = st.text(
+    alphabet=st.one_of(
+        st.characters(blacklist_categories=("Cs", "Cc", "Zl", "Zp")),
+        st.sampled_from(',;"\n\t'),
+    ),
+    max_size=40,
+)
+RECORDS = st.lists(
+    st.builds(
+        takt.FileRow,
+        st.datetimes(
+            min_value=pd.Timestamp("1990-01-01").to_pydatetime(),
+            max_value=pd.Timestamp("2100-01-01").to_pydatetime(),
+        ).map(lambda d: pd.Timestamp(d.replace(microsecond=0))),
+        st.sampled_from(takt.RECORD_KINDS),
+        NOTES,
+    ),
+    max_size=20,
+).map(lambda rs: sorted(rs, key=lambda r: r[takt.TIMESTAMP], reverse=True))
+FUZZ = settings(
+    deadline=None, suppress_health_check=[HealthCheck.function_scoped_fixture]
+)
+
+
+def expected(records):
+    notes = [r[takt.NOTES].strip() for r in records]
+    return [
+        (r[takt.TIMESTAMP], r[takt.KIND], "" if n in takt.LEGACY_NAN else n)
+        for r, n in zip(records, notes)
+    ]
+
+
+def loaded(records):
+    return [(r[takt.TIMESTAMP], r[takt.KIND], r[takt.NOTES]) for r in records]
+
+
+@FUZZ
+@given(RECORDS, st.sampled_from(["utf-8", "utf-8-sig", "utf-16"]), st.booleans())
+def test_written_records_read_back(tmp_path, records, encoding, crlf):
+    # Excel saves with a BOM, in UTF-16 and with Windows line ends
+    text = takt.records_to_csv(records, takt.COLUMNS)
+    if crlf:
+        text = text.replace("\n", "\r\n")
+    path = tmp_path / "takt.csv"
+    path.write_bytes(text.encode(encoding))
+    manager = takt.FileManager(str(path), journal=False)
+    assert loaded(manager.load()) == expected(records)
+    assert loaded(manager.iter_records()) == expected(records)
+
+
+@FUZZ
+@given(st.binary(max_size=200))
+def test_reader_fails_only_with_takt_errors(tmp_path, body):
+    path = tmp_path / "takt.csv"
+    for head in (b"timestamp,kind,notes\n", codecs.BOM_UTF8 + b"timestamp;kind\n"):
+        path.write_bytes(head + body)
+        manager = takt.FileManager(str(path), lenient=True, journal=False)
+        for read in (manager.load, lambda: list(manager.iter_records())):
+            try:
+                read()
+            except takt.TaktError:
+                pass
+
+
+def test_iter_rows_handles_short_lines():
+    raw = b"timestamp,kind,notes\n2024-07-01 09:00:00\n"
+    assert list(takt.iter_rows(io.BytesIO(raw))) == [
+        (2, {"timestamp": "2024-07-01 09:00:00", "kind": "", "notes": ""})
+    ]
+
+
+@settings(deadline=None)
+@given(st.text(max_size=40))
+def test_parse_timestamp_fails_only_with_invalid_args(text):
+    try:
+        timestamp = takt.parse_timestamp(text)
+    except takt.InvalidArgsError:
+        return
+    assert isinstance(timestamp, pd.Timestamp)
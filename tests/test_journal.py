@@ -0,0 +1,36 @@
+import pandas as pd
+
+import takt
+
+
+def row(minutes, kind="in"):
+    timestamp = pd.Timestamp("2024-07-01 09:00") + pd.Timedelta(minutes=minutes)
+    return takt.FileRow(timestamp, kind, "")
+
+
+def test_journal_is_replayed_only_after_outside_edits(records, monkeypatch):
+    manager = takt.FileManager(str(records))
+    manager.exists()
+    manager.insert(**row(0))
+    replays = []
+    replay = takt.Journal.replay
+    monkeypatch.setattr(
+        takt.Journal, "replay", lambda self: replays.append(1) or replay(self)
+    )
+    manager.insert(**row(60, "out"))
+    assert replays == []
+
+    with open(records, "a") as f:
+        f.write("2024-07-01 08:00:00,in,\n")
+    manager.insert(**row(120))
+    assert replays == [1]
+    replayed = [r[takt.TIMESTAMP] for r in manager.journal.replay()]
+    assert replayed == [r[takt.TIMESTAMP] for r in manager.load()]
+
+
+def test_journal_uses_the_clock(records, monkeypatch):
+    monkeypatch.setattr(takt, "clock", takt.FixedClock("2024-07-01 18:00"))
+    manager = takt.FileManager(str(records))
+    manager.exists()
+    manager.insert(**row(0))
+    assert {e["at"] for e in manager.journal.events()} == {"2024-07-01T18:00:00"}
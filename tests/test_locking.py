@@ -0,0 +1,54 @@
+import sys
+import threading
+from pathlib import Path
+
+import pandas as pd
+from typer.testing import CliRunner
+
+import takt
+
+TAKT = Path(takt.__file__).resolve()
+
+
+def test_hook_can_write_records(records, config):
+    # the hook runs once the lock is released, its own check doesn't wait
+    config(
+        "[hooks]\n"
+        f"post_check = '\"{sys.executable}\" \"{TAKT}\" check --note from-hook'\n"
+    )
+    result = CliRunner().invoke(takt.app, ["check"])
+    assert result.exit_code == 0, result.output
+    kinds = [r[takt.KIND] for r in takt.FileManager(str(records)).load()]
+    assert kinds == ["out", "in"]
+
+
+def test_threads_dont_lose_writes(records):
+    manager = takt.FileManager(str(records), journal=False)
+    manager.exists()
+    start = pd.Timestamp("2024-07-01 09:00")
+
+    def write(offset):
+        for i in range(20):
+            timestamp = start + pd.Timedelta(minutes=2 * i + offset)
+            takt.FileManager(str(records), journal=False).insert(
+                **takt.FileRow(timestamp, "in", f"thread {offset}")
+            )
+
+    threads = [threading.Thread(target=write, args=(n,)) for n in (0, 1)]
+    for thread in threads:
+        thread.start()
+    for thread in threads:
+        thread.join()
+    assert len(manager.load()) == 40
+    assert not takt.FileLock.held
+
+
+def test_stale_lock_notice_goes_to_stderr(records, capsys):
+    lock = takt.FileLock(str(records))
+    # no process has a pid this high
+    Path(lock.path).write_text("4194305")
+    with lock:
+        pass
+    out, err = capsys.readouterr()
+    assert out == ""
+    assert "Removing stale lock" in err
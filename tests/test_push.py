@@ -0,0 +1,38 @@
+import json
+
+import pandas as pd
+from typer.testing import CliRunner
+
+import takt
+
+
+def test_push_jira_saves_each_worklog_and_skips_open(records, tmp_path, monkeypatch):
+    pushed_file = tmp_path / "pushed.json"
+    monkeypatch.setattr(takt, "PUSHED_FILE", str(pushed_file))
+    day = pd.Timestamp("2024-07-01")
+    monkeypatch.setattr(takt, "clock", takt.FixedClock(day + pd.Timedelta(hours=17)))
+    manager = takt.FileManager(str(records), journal=False)
+    manager.exists()
+    auto_out = takt.format_meta({"inferred": "auto-out"})
+    for hour, kind, notes, meta in [
+        (9, "in", "PROJ-1", ""),
+        (10, "out", "Inferred by takt.", auto_out),
+        (11, "in", "PROJ-2", ""),
+        (12, "out", "", ""),
+        (13, "in", "PROJ-3", ""),
+    ]:
+        manager.insert(**takt.FileRow(day + pd.Timedelta(hours=hour), kind, notes, meta))
+
+    def worklog(config, key, session, duration):
+        if key == "PROJ-2":
+            raise OSError("unreachable")
+        return "10001"
+
+    monkeypatch.setattr(takt, "jira_worklog", worklog)
+    result = CliRunner().invoke(
+        takt.app, ["push", "jira", "--from", "2024-07-01", "--to", "2024-07-01"]
+    )
+    assert result.exit_code != 0
+    assert "still open" in result.output
+    done = json.loads(pushed_file.read_text())["jira"]
+    assert list(done) == ["PROJ-1@2024-07-01T09:00:00"]
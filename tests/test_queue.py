@@ -0,0 +1,42 @@
+import json
+import shutil
+from pathlib import Path
+
+from typer.testing import CliRunner
+
+import takt
+
+
+def test_retry_without_queue_directory():
+    shutil.rmtree(takt.QUEUE_DIR, ignore_errors=True)
+    assert takt.retry_queue() == (0, 0)
+
+
+def test_failing_hook_is_dropped_after_max_attempts(tmp_path):
+    snapshot = tmp_path / "takt.csv"
+    snapshot.write_text("timestamp,kind,notes\n")
+    takt.enqueue_hook("post_check", "false", {}, str(snapshot), 1)
+    entries = takt.load_queue()
+    entries[-1]["attempts"] = takt.QUEUE_MAX_ATTEMPTS - 1
+    Path(takt.QUEUE_FILE).write_text(json.dumps(entries))
+    copy = entries[-1]["snapshot"]
+
+    assert takt.retry_queue(force=True) == (0, 1)
+    assert takt.load_queue() == []
+    assert not Path(copy).exists()
+
+
+def test_maintenance_keeps_lock_and_temporary_files(records):
+    shutil.rmtree(takt.QUEUE_DIR, ignore_errors=True)
+    result = CliRunner().invoke(takt.app, ["maintenance"])
+    assert result.exit_code == 0, result.output
+
+    queue = Path(takt.QUEUE_DIR)
+    orphan = queue / "0123abcd-takt.csv"
+    writing = queue / ".takt-1234.tmp"
+    orphan.write_text("")
+    writing.write_text("")
+    result = CliRunner().invoke(takt.app, ["maintenance"])
+    assert result.exit_code == 0, result.output
+    assert not orphan.exists()
+    assert writing.exists()
@@ -0,0 +1,126 @@
+import pandas as pd
+import pytest
+from typer.testing import CliRunner
+
+import takt
+
+
+def write(records, rows):
+    manager = takt.FileManager(str(records), journal=False)
+    manager.exists()
+    for row in rows:
+        manager.insert(**takt.FileRow(*row))
+    return manager
+
+
+def test_rm_last_skips_leave_ahead(records):
+    now = pd.Timestamp.now().floor("s")
+    manager = write(records, [
+        (now - pd.Timedelta(hours=2), "in", "work"),
+        (now + pd.Timedelta(days=7), takt.LEAVE, "", "leave=vacation"),
+    ])
+    result = CliRunner().invoke(takt.app, ["rm", "--last", "--yes", "--force"])
+    assert result.exit_code == 0, result.output
+    assert [r[takt.KIND] for r in manager.load()] == [takt.LEAVE]
+
+
+def test_remove_changed_meanwhile_removes_nothing(records):
+    start = pd.Timestamp("2024-07-01 09:00")
+    manager = write(records, [
+        (start, "in", "work"),
+        (start + pd.Timedelta(hours=1), "out", ""),
+    ])
+    stale = manager.load()
+    manager.update(stale[0], {takt.NOTES: "edited"})
+    with pytest.raises(takt.TaktError):
+        manager.remove(stale[:1])
+    assert len(manager.load()) == 2
+
+
+def test_import_matches_records_without_seconds(records, tmp_path):
+    start = pd.Timestamp("2024-07-01 09:00:42")
+    manager = write(records, [
+        (start, "in", "work"),
+        (start + pd.Timedelta(hours=1), "out", ""),
+    ])
+    edited = tmp_path / "edited.csv"
+    edited.write_text(
+        "timestamp;kind;notes\n"
+        "01/07/2024 10:30;in;after lunch\n"
+        "01/07/2024 10:00;out;\n"
+        "01/07/2024 09:00;in;work +client\n"
+    )
+    result = CliRunner().invoke(takt.app, ["import", "excel", str(edited)])
+    assert result.exit_code == 0, result.output
+    loaded = manager.load()
+    assert [r[takt.NOTES] for r in loaded] == ["after lunch", "", "work +client"]
+    assert loaded[-1][takt.TIMESTAMP] == start
+
+
+@pytest.mark.parametrize("timestamp", [
+    "2023/11/09 10:15:00", "2023-11-09 10:15", "2023-11-09T10:15:00+00:00",
+])
+def test_prompt_reads_every_timestamp_form(records, monkeypatch, timestamp):
+    monkeypatch.setattr(takt, "clock", takt.FixedClock("2023-11-10 10:15"))
+    records.write_text(f"timestamp,kind,notes\n{timestamp},in,+web\n")
+    result = CliRunner().invoke(takt.app, ["prompt", "-f", "{project} {since}"])
+    assert result.exit_code == 0, result.output
+    # an offset is shown in local time
+    local = pd.Timestamp(timestamp).to_pydatetime()
+    if local.tzinfo is not None:
+        local = local.astimezone()
+    assert result.output == f"web {local:%H:%M}\n"
+
+
+def test_prompt_prints_nothing_on_a_bad_timestamp(records):
+    records.write_text("timestamp,kind,notes\nnot a date,in,\n")
+    result = CliRunner().invoke(takt.app, ["prompt"])
+    assert result.exit_code == 0
+    assert result.output == ""
+
+
+def test_rm_by_line(records):
+    start = pd.Timestamp("2024-07-01 09:00")
+    manager = write(records, [(start, "in", "a"), (start, "in", "b")])
+    # newest first, line 2 is the last one inserted
+    result = CliRunner().invoke(
+        takt.app, ["rm", "--line", "2", "--yes", "--force"]
+    )
+    assert result.exit_code == 0, result.output
+    assert [r[takt.NOTES] for r in manager.load()] == ["a"]
+
+
+def test_rm_to_a_date_includes_that_day(records):
+    manager = write(records, [
+        (pd.Timestamp("2024-07-03 09:00"), "in", ""),
+        (pd.Timestamp("2024-07-03 17:00"), "out", ""),
+        (pd.Timestamp("2024-07-04 09:00"), "in", ""),
+    ])
+    result = CliRunner().invoke(
+        takt.app, ["rm", "--from", "2024-07-03", "--to", "2024-07-03", "--yes"]
+    )
+    assert result.exit_code == 0, result.output
+    assert [r[takt.TIMESTAMP] for r in manager.load()] == [
+        pd.Timestamp("2024-07-04 09:00")
+    ]
+
+
+def test_reader_warnings_go_to_stderr(records, capsys):
+    records.write_text(
+        "timestamp,kind,notes\n"
+        "2024-07-01 08:00,in,\n2024-07-01 09:00,out,\nnot a date,in,\n"
+    )
+    takt.FileManager(str(records), lenient=True, journal=False).load()
+    out, err = capsys.readouterr()
+    assert out == ""
+    assert "Line skipped" in err
+    assert "not sorted" in err
+
+
+def test_public_api_names(records):
+    assert set(takt.__all__) <= set(vars(takt))
+    assert takt.Store is takt.FileManager
+    assert takt.Record is takt.FileRow
+    assert takt.plugins == {}
+    manager = write(records, [(pd.Timestamp("2024-07-01 09:00"), "in", "")])
+    assert takt.Store(str(records), journal=False).load() == manager.load()
@@ -0,0 +1,115 @@
+import json
+import threading
+import urllib.error
+import urllib.request
+from http.server import ThreadingHTTPServer
+
+import pandas as pd
+import pytest
+from typer.testing import CliRunner
+
+import takt
+
+
+@pytest.fixture
+def server(records):
+    server = ThreadingHTTPServer(("127.0.0.1", 0), takt.TaktHandler)
+    thread = threading.Thread(target=server.serve_forever, daemon=True)
+    thread.start()
+    yield f"http://127.0.0.1:{server.server_address[1]}"
+    server.shutdown()
+    server.server_close()
+
+
+@pytest.mark.parametrize("exception", [RuntimeError, takt.GitError])
+def test_unexpected_error_is_a_generic_500(server, monkeypatch, exception):
+    def broken(self, query):
+        raise exception("/home/alice/secret")
+
+    monkeypatch.setattr(takt.TaktHandler, "get_records", broken)
+    with pytest.raises(urllib.error.HTTPError) as error:
+        urllib.request.urlopen(f"{server}/records", timeout=5)
+    assert error.value.code == 500
+    assert json.load(error.value) == {"error": "Internal server error."}
+
+
+def test_readyz_before_the_first_check(server, records):
+    with urllib.request.urlopen(f"{server}/readyz", timeout=5) as response:
+        assert json.load(response) == {"status": "ok"}
+
+
+def test_serve_refuses_open_network_without_auth(records, monkeypatch):
+    monkeypatch.delenv("TAKT_TOKEN", raising=False)
+    result = CliRunner().invoke(takt.app, ["serve", "--host", "0.0.0.0"])
+    assert result.exit_code != 0
+    assert "needs authentication" in result.output
+
+
+def team_files(tmp_path, sessions):
+    """Records file of each member, with a 2h session of each project given."""
+    start = pd.Timestamp.now().normalize() - pd.Timedelta(days=1, hours=-9)
+    files = []
+    for name, projects in sessions.items():
+        manager = takt.FileManager(str(tmp_path / f"{name}.csv"), journal=False)
+        manager.exists()
+        for n, project in enumerate(projects):
+            check_in = start + pd.Timedelta(hours=3 * n)
+            manager.insert(**takt.FileRow(check_in, "in", f"+{project}"))
+            manager.insert(**takt.FileRow(
+                check_in + pd.Timedelta(hours=2), "out", ""
+            ))
+        files.append(manager.filename)
+    return files
+
+
+def test_team_summary_hides_who_tracked_what(tmp_path):
+    files = team_files(tmp_path, {
+        "ana": ["web"], "ben": ["web"], "eva": ["web", "api"],
+    })
+    week = takt.team_summary(files)[0]
+    assert week["members"] == 3
+    # only eva worked on api, those hours would be hers
+    assert week["projects"] == [("web", pd.Timedelta(hours=6))]
+    assert week["duration"] == pd.Timedelta(hours=6)
+
+
+def test_team_summary_merges_small_projects(tmp_path):
+    files = team_files(tmp_path, {"ana": ["web"], "ben": ["api"], "eva": ["ops"]})
+    week = takt.team_summary(files)[0]
+    assert week["projects"] == [(None, pd.Timedelta(hours=6))]
+
+
+def test_team_summary_leaves_out_a_single_member(tmp_path):
+    files = team_files(tmp_path, {"ana": ["web", "api"], "ben": [], "eva": []})
+    assert takt.team_summary(files) == []
+
+
+def test_export_and_backup_errors_are_json(server, records, monkeypatch):
+    def locked(self):
+        raise takt.LockError(f"{self.filename} is locked.")
+
+    monkeypatch.setattr(takt.FileManager, "export_zip", locked)
+    records.write_text("timestamp,kind,notes\nnot a date,in,\n")
+    for path in ("/export", "/backup"):
+        with pytest.raises(urllib.error.HTTPError) as error:
+            urllib.request.urlopen(f"{server}{path}", timeout=5)
+        assert error.value.code == 500
+        assert json.load(error.value) == {"error": "Internal server error."}
+
+
+def test_feed_error_is_json(server, records, monkeypatch):
+    monkeypatch.setattr(takt.TaktHandler, "feed_secret", "s3cret")
+    records.write_text("timestamp,kind,notes\nnot a date,in,\n")
+    signature = takt.feed_signature("s3cret", takt.ALL_SESSIONS)
+    with pytest.raises(urllib.error.HTTPError) as error:
+        urllib.request.urlopen(
+            f"{server}{takt.CALENDAR_FEED}?sig={signature}", timeout=5
+        )
+    assert error.value.code == 500
+    assert json.load(error.value) == {"error": "Internal server error."}
+
+
+def test_events_before_the_first_check(server, records):
+    with urllib.request.urlopen(f"{server}/events", timeout=5) as stream:
+        assert stream.readline() == b"event: records\n"
+        assert stream.readline() == b"data: []\n"